@@ -1,6 +1,9 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
+
 	"github.com/ubombar/soa/internal/datetime"
 )
 
@@ -26,9 +29,26 @@ type QuestionBuffer struct {
 }
 
 type LiteratureHeader struct {
-	Created datetime.Date `buffer:"created"` // creation date
-	PDF     string        `buffer:"pdf"`     // path to the pdf file
-	Tags    []string      `buffer:"tags"`    // tags of the note
+	Created      datetime.Date `buffer:"created"`      // creation date
+	CitationKey  string        `buffer:"citation_key"` // citation key, used to find the note on re-sync
+	ZoteroKey    string        `buffer:"zotero_key"`   // item key in the zotero library
+	Title        string        `buffer:"title"`        // title of the item
+	Authors      []string      `buffer:"authors"`      // authors as "Last, First"
+	Year         string        `buffer:"year"`         // publication year
+	Date         string        `buffer:"date"`         // publication date as written in zotero
+	ItemType     string        `buffer:"item_type"`    // zotero item type, e.g. journalArticle
+	Venue        string        `buffer:"venue"`        // journal, conference or publisher
+	Volume       string        `buffer:"volume"`       // volume of the venue
+	Issue        string        `buffer:"issue"`        // issue of the venue
+	Pages        string        `buffer:"pages"`        // page range
+	DOI          string        `buffer:"doi"`          // digital object identifier
+	URL          string        `buffer:"url"`          // url of the item
+	Abstract     string        `buffer:"abstract"`     // abstract of the item
+	Collections  []string      `buffer:"collections"`  // zotero collection keys of the item
+	PDF          string        `buffer:"pdf"`          // path to the pdf file
+	Attachements []string      `buffer:"attachements"` // paths of the attachements the annotations are taken from
+	Tags         []string      `buffer:"tags"`         // tags of the note, includes the zotero tags
+	ZoteroTags   []string      `buffer:"zotero_tags"`  // tags taken from zotero on the last sync, removed from tags once removed in zotero
 }
 
 func (h LiteratureHeader) Kind() string {
@@ -36,7 +56,13 @@ func (h LiteratureHeader) Kind() string {
 }
 
 type MeetingHeader struct {
-	Created datetime.Date `buffer:"created"` // creation date
+	Created   datetime.Date     `buffer:"created"`   // creation date
+	Title     string            `buffer:"title"`     // title of the meeting
+	Project   string            `buffer:"project"`   // project the meeting belongs to
+	Attendees []string          `buffer:"attendees"` // people attending the meeting
+	Start     datetime.DateTime `buffer:"start"`     // start time of the meeting
+	Duration  string            `buffer:"duration"`  // duration of the meeting, e.g. 30m, 1h30m
+	Tags      []string          `buffer:"tags"`      // tags of the note
 }
 
 func (h MeetingHeader) Kind() string {
@@ -45,16 +71,36 @@ func (h MeetingHeader) Kind() string {
 
 type PermanentHeader struct {
 	Created datetime.Date `buffer:"created"` // creation date
+	ID      string        `buffer:"id"`      // zettel id, unique across the vault
+	Title   string        `buffer:"title"`   // title of the note
+	Tags    []string      `buffer:"tags"`    // tags of the note
+	Sources []string      `buffer:"sources"` // notes or references this note is based on
+	Links   []string      `buffer:"links"`   // related notes
 }
 
 func (h PermanentHeader) Kind() string {
 	return "permanent"
 }
 
-type ZoteroAttachementResponse struct {
-	JSONRPC string                  `json:"jsonrpc"`
-	Result  []ZoteroAttachementItem `json:"result"`
-	ID      *string                 `json:"id"` // or simply string if `null` never appears.
+type ZoteroRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *ZoteroRPCError `json:"error"`
+	ID      json.RawMessage `json:"id"` // string, number or null
+}
+
+type ZoteroRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ZoteroRPCError) Error() string {
+	return fmt.Sprintf("zotero json-rpc error %d: %s", e.Code, e.Message)
+}
+
+// Result of the item.export call when Better BibTeX JSON translator is used.
+type ZoteroExport struct {
+	Items []ZoteroItemDetails `json:"items"`
 }
 
 type ZoteroAttachementItem struct {
@@ -90,15 +136,39 @@ const (
 	ColorGray    AnnotationColor = "#aaaaaa" // unused
 )
 
-var AnnotationColorNames = map[AnnotationColor]string{
-	ColorYellow:  "Yellow",
-	ColorRed:     "Red",
-	ColorGreen:   "Green",
-	ColorBlue:    "Blue",
-	ColorPurple:  "Purple",
-	ColorMagenta: "Magenta",
-	ColorOrage:   "Orage",
-	ColorGray:    "Gray",
+// Describes what an annotation color means for the reader, used to label and
+// group the annotations in literature notes.
+type ColorMeaning struct {
+	Color   AnnotationColor `mapstructure:"color"`
+	Name    string          `mapstructure:"name"`
+	Icon    string          `mapstructure:"icon"`
+	Meaning string          `mapstructure:"meaning"`
+}
+
+// Ordered list of color meanings, the order is used when annotations are
+// grouped by color.
+type ColorScheme []ColorMeaning
+
+// Returns the meaning of the given color, unknown colors get a question mark
+// icon.
+func (s ColorScheme) Get(color AnnotationColor) ColorMeaning {
+	for _, meaning := range s {
+		if meaning.Color == color {
+			return meaning
+		}
+	}
+	return ColorMeaning{Color: color, Name: string(color), Icon: "❓", Meaning: "Other"}
+}
+
+var DefaultColorScheme = ColorScheme{
+	{Color: ColorPurple, Name: "Purple", Icon: "🟪", Meaning: "Very important"},
+	{Color: ColorYellow, Name: "Yellow", Icon: "🟨", Meaning: "Important"},
+	{Color: ColorBlue, Name: "Blue", Icon: "🟦", Meaning: "Agreements and good ideas"},
+	{Color: ColorRed, Name: "Red", Icon: "🟥", Meaning: "Disagreements, needs fact check"},
+	{Color: ColorGreen, Name: "Green", Icon: "🟩", Meaning: "Unknown concepts"},
+	{Color: ColorMagenta, Name: "Magenta", Icon: "❓", Meaning: "Other"},
+	{Color: ColorOrage, Name: "Orage", Icon: "❓", Meaning: "Other"},
+	{Color: ColorGray, Name: "Gray", Icon: "❓", Meaning: "Other"},
 }
 
 type ZoteroAnnotation struct {
@@ -154,6 +224,10 @@ type ZoteroItemDetails struct {
 	Volume           string                 `json:"volume,omitempty"`
 	Pages            string                 `json:"pages,omitempty"`
 	PublicationTitle string                 `json:"publicationTitle,omitempty"`
+	ProceedingsTitle string                 `json:"proceedingsTitle,omitempty"`
+	ConferenceName   string                 `json:"conferenceName,omitempty"`
+	BookTitle        string                 `json:"bookTitle,omitempty"`
+	Publisher        string                 `json:"publisher,omitempty"`
 	DOI              string                 `json:"DOI,omitempty"`
 	Issue            string                 `json:"issue,omitempty"`
 	ISSN             string                 `json:"ISSN,omitempty"`
@@ -174,5 +248,6 @@ type ZoteroItemDetails struct {
 type ZoteroCreator struct {
 	FirstName   string `json:"firstName"`
 	LastName    string `json:"lastName"`
+	Name        string `json:"name,omitempty"` // single field names, e.g. institutions
 	CreatorType string `json:"creatorType"`
 }
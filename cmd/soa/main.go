@@ -9,8 +9,17 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/ubombar/soa/internal/add"
+	"github.com/ubombar/soa/internal/backlinks"
+	"github.com/ubombar/soa/internal/config"
+	"github.com/ubombar/soa/internal/doctor"
+	"github.com/ubombar/soa/internal/index"
+	"github.com/ubombar/soa/internal/list"
 	"github.com/ubombar/soa/internal/log"
+	"github.com/ubombar/soa/internal/migrate"
+	"github.com/ubombar/soa/internal/move"
+	"github.com/ubombar/soa/internal/search"
 	"github.com/ubombar/soa/internal/sync"
+	"github.com/ubombar/soa/internal/validate"
 )
 
 var logger = log.GlobalLogger
@@ -28,10 +37,20 @@ func main() {
 	}
 	rootCmd.PersistentFlags().Bool("debug", false, "enable debug messages")
 	rootCmd.PersistentFlags().String("vault-dir", vaultDir, "vault dir")
+	rootCmd.PersistentFlags().Bool(config.KeyNoLock, false, "do not take the vault lock, only use when no other soa process runs on the vault")
 
 	// add other commands
 	rootCmd.AddCommand(add.AddCmd())
 	rootCmd.AddCommand(sync.SyncCmd())
+	rootCmd.AddCommand(index.IndexCmd())
+	rootCmd.AddCommand(list.ListCmd())
+	rootCmd.AddCommand(search.SearchCmd())
+	rootCmd.AddCommand(backlinks.BacklinksCmd())
+	rootCmd.AddCommand(doctor.DoctorCmd())
+	rootCmd.AddCommand(move.MoveCmd())
+	rootCmd.AddCommand(move.RetitleCmd())
+	rootCmd.AddCommand(validate.ValidateCmd())
+	rootCmd.AddCommand(migrate.MigrateCmd())
 
 	// bind variables to viper
 	viper.BindPFlags(rootCmd.PersistentFlags())
@@ -58,5 +77,16 @@ func rootCmdPersistentPreRunE(cmd *cobra.Command, args []string) error {
 		return errors.New("vault-dir flag is not given and SAO_DIR env variable is not set")
 	}
 
+	// read the optional config file under the vault
+	viper.SetConfigName(config.ConfigFileName)
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(vaultDir)
+	if err := viper.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) {
+			return err
+		}
+	}
+
 	return nil
 }
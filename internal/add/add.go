@@ -1,13 +1,17 @@
 package add
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/ubombar/soa/internal/config"
+	"github.com/ubombar/soa/internal/datetime"
 	"github.com/ubombar/soa/internal/log"
 	"github.com/ubombar/soa/pkg/client"
 )
@@ -33,13 +37,16 @@ func AddCmd() *cobra.Command {
 	}
 
 	addLiteratureCmd := &cobra.Command{
-		Use:     "literature",
+		Use:     "literature <citationKey>...",
 		Aliases: []string{"l"},
 		Short:   "Add literature note",
-		Long:    "Add literature note under the soa directory",
+		Long:    "Add literature notes of the given citation keys under the soa directory, without the interactive picker",
 		Args:    addLiteratureCmdArgs,
 		Run:     addLiteratureCmd,
 	}
+	addLiteratureCmd.Flags().StringP("attachements", "a", string(client.AttachementPolicyFirst), "attachement policy: first (first pdf), merge (all attachements) or none (metadata only)")
+	addLiteratureCmd.Flags().StringP("group-by", "g", "", "group annotations: none or color, defaults to literature-group-by config")
+	addLiteratureCmd.Flags().String("on-conflict", "", "when the note changed since it was read: fail, merge or overwrite, defaults to on-conflict config")
 
 	addMeetingCmd := &cobra.Command{
 		Use:     "meeting",
@@ -49,6 +56,10 @@ func AddCmd() *cobra.Command {
 		Args:    addMeetingCmdArgs,
 		Run:     addMeetingCmd,
 	}
+	addMeetingCmd.Flags().StringArrayP("attendee", "a", []string{}, "attendee of the meeting, can be given multiple times")
+	addMeetingCmd.Flags().StringP("project", "p", "", "project the meeting belongs to")
+	addMeetingCmd.Flags().StringP("start", "s", "", "start time of the meeting as 'YYYY-MM-DD HH:MM', defaults to now")
+	addMeetingCmd.Flags().StringP("duration", "d", "1h", "duration of the meeting, e.g. 30m, 1h30m")
 
 	addPermentantCmd := &cobra.Command{
 		Use:     "permanent",
@@ -58,6 +69,9 @@ func AddCmd() *cobra.Command {
 		Args:    addPermanentCmdArgs,
 		Run:     addPermanentCmd,
 	}
+	addPermentantCmd.Flags().StringArrayP("tag", "t", []string{}, "tag of the note, can be given multiple times")
+	addPermentantCmd.Flags().StringArrayP("source", "s", []string{}, "source of the note, can be given multiple times")
+	addPermentantCmd.Flags().StringArrayP("link", "l", []string{}, "related note, can be given multiple times")
 
 	// add under add command
 	addCmd.AddCommand(addQuestionCmd)
@@ -106,27 +120,139 @@ func addQuestionCmdArgs(cmd *cobra.Command, args []string) error {
 
 // literature
 func addLiteratureCmd(cmd *cobra.Command, args []string) {
-	cmd.Help()
+	logger := log.GlobalLogger
+	rawPolicy, _ := cmd.Flags().GetString("attachements")
+	policy, err := client.ParseAttachementPolicy(rawPolicy)
+	if err != nil {
+		logger.Fatalf("cannot parse attachement policy: %v.\n", err)
+		os.Exit(1)
+	}
+	if cmd.Flags().Changed("group-by") { // flag takes precedence over the config file
+		groupBy, _ := cmd.Flags().GetString("group-by")
+		viper.Set(config.KeyLiteratureGroupBy, groupBy)
+	}
+	if cmd.Flags().Changed("on-conflict") {
+		onConflict, _ := cmd.Flags().GetString("on-conflict")
+		viper.Set(config.KeyOnConflict, onConflict)
+	}
+
+	zclient, err := client.NewZoteroClient(nil)
+	if err != nil {
+		logger.Fatalf("cannot create zotero client: %v.\n", err)
+		os.Exit(1)
+	}
+	bclient, err := client.NewBufferClient(nil)
+	if err != nil {
+		logger.Fatalf("cannot create buffer client: %v.\n", err)
+		os.Exit(1)
+	}
+
+	// keys are resolved one at a time and failures are reported at the end,
+	// so one unknown key or failing entry does not abort the others
+	failures := []error{}
+	for _, citationKey := range args {
+		entries, err := zclient.GetCitationEntries([]string{citationKey})
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %w", citationKey, err))
+			continue
+		}
+		literatureBuffer, err := bclient.SyncLiterature(zclient, &entries[0], policy, false)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %w", citationKey, err))
+			continue
+		}
+
+		fmt.Printf("%s\n", literatureBuffer.Origin)
+	}
+
+	if len(failures) != 0 {
+		for _, err := range failures {
+			logger.Errorf("cannot create literature %v.\n", err)
+		}
+		logger.Fatalf("%d of %d literature notes failed.\n", len(failures), len(args))
+		os.Exit(1)
+	}
 }
 
 func addLiteratureCmdArgs(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return errors.New("citation key is not given")
+	}
 	return nil
 }
 
 // meeting
 func addMeetingCmd(cmd *cobra.Command, args []string) {
-	cmd.Help()
+	logger := log.GlobalLogger
+	rawTitle := strings.Join(args, " ")
+	attendees, _ := cmd.Flags().GetStringArray("attendee")
+	project, _ := cmd.Flags().GetString("project")
+	rawStart, _ := cmd.Flags().GetString("start")
+	rawDuration, _ := cmd.Flags().GetString("duration")
+
+	start := datetime.CurrentDateTime()
+	if rawStart != "" {
+		parsed, err := datetime.ParseDateTime(rawStart)
+		if err != nil {
+			logger.Fatalf("cannot parse start time: %v.\n", err)
+			os.Exit(1)
+		}
+		start = parsed
+	}
+
+	if _, err := time.ParseDuration(rawDuration); err != nil {
+		logger.Fatalf("cannot parse duration: %v.\n", err)
+		os.Exit(1)
+	}
+
+	bclient, err := client.NewBufferClient(nil)
+	if err != nil {
+		logger.Fatalf("cannot create buffer client: %v.\n", err)
+		os.Exit(1)
+	}
+
+	meetingBuffer, err := bclient.NewMeeting(rawTitle, project, attendees, start, rawDuration, false)
+	if err != nil {
+		logger.Fatalf("cannot create meeting: %v.\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s\n", meetingBuffer.Origin)
 }
 
 func addMeetingCmdArgs(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return errors.New("meeting title is not given")
+	}
 	return nil
 }
 
 // permenant
 func addPermanentCmd(cmd *cobra.Command, args []string) {
-	cmd.Help()
+	logger := log.GlobalLogger
+	rawTitle := strings.Join(args, " ")
+	tags, _ := cmd.Flags().GetStringArray("tag")
+	sources, _ := cmd.Flags().GetStringArray("source")
+	links, _ := cmd.Flags().GetStringArray("link")
+
+	bclient, err := client.NewBufferClient(nil)
+	if err != nil {
+		logger.Fatalf("cannot create buffer client: %v.\n", err)
+		os.Exit(1)
+	}
+
+	permanentBuffer, err := bclient.NewPermanent(rawTitle, tags, sources, links, false)
+	if err != nil {
+		logger.Fatalf("cannot create permanent: %v.\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s\n", permanentBuffer.Origin)
 }
 
 func addPermanentCmdArgs(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return errors.New("permanent note title is not given")
+	}
 	return nil
 }
@@ -0,0 +1,127 @@
+package backlinks
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ubombar/soa/internal/log"
+	"github.com/ubombar/soa/pkg/client"
+	"github.com/ubombar/soa/pkg/index"
+)
+
+func BacklinksCmd() *cobra.Command {
+	backlinksCmd := &cobra.Command{
+		Use:     "backlinks <note>",
+		Aliases: []string{"b"},
+		Short:   "Show the backlinks of a note",
+		Long:    "Show the notes linking to the given note, the note can be given as a path or a name",
+		Args:    backlinksCmdArgs,
+		Run:     backlinksCmd,
+	}
+	backlinksCmd.Flags().BoolP("write", "w", false, "write the backlinks into the managed backlinks section of the note")
+	backlinksCmd.Flags().Bool("all", false, "regenerate the backlinks section of every note that has one")
+	backlinksCmd.Flags().String("format", "text", "output format: text or paths")
+
+	return backlinksCmd
+}
+
+func backlinksCmd(cmd *cobra.Command, args []string) {
+	logger := log.GlobalLogger
+	write, _ := cmd.Flags().GetBool("write")
+	all, _ := cmd.Flags().GetBool("all")
+	format, _ := cmd.Flags().GetString("format")
+
+	bclient, err := client.NewBufferClient(nil)
+	if err != nil {
+		logger.Fatalf("cannot create buffer client: %v.\n", err)
+		os.Exit(1)
+	}
+
+	idx, err := index.Open(bclient, viper.GetString("vault-dir"))
+	if err != nil {
+		logger.Fatalf("cannot open index: %v.\n", err)
+		os.Exit(1)
+	}
+
+	if all {
+		for _, note := range idx.All() {
+			if note.Error != "" {
+				continue
+			}
+			if err := writeBacklinks(bclient, idx, note, true); err != nil {
+				logger.Fatalf("cannot write backlinks of %s: %v.\n", note.Path, err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	note, err := idx.Find(args[0])
+	if err != nil {
+		logger.Fatalf("cannot find %s: %v.\n", args[0], err)
+		os.Exit(1)
+	}
+
+	for _, backlink := range idx.Backlinks(note) {
+		switch format {
+		case "paths":
+			fmt.Println(idx.Abs(backlink.Source))
+		default:
+			location := backlink.Link.Key
+			if backlink.Link.Type != client.HeaderLink {
+				location = fmt.Sprintf("%d", backlink.Link.Line)
+			}
+			fmt.Printf("%s:%s (%s)\n", backlink.Source.Path, location, backlink.Link.Type)
+		}
+	}
+
+	if write {
+		if err := writeBacklinks(bclient, idx, note, false); err != nil {
+			logger.Fatalf("cannot write backlinks of %s: %v.\n", note.Path, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func backlinksCmdArgs(cmd *cobra.Command, args []string) error {
+	if all, _ := cmd.Flags().GetBool("all"); all {
+		return cobra.NoArgs(cmd, args)
+	}
+	if len(args) != 1 {
+		return errors.New("note is not given")
+	}
+	return nil
+}
+
+// Regenerates the backlinks section of the note, with onlyExisting notes
+// without a backlinks section are left alone.
+func writeBacklinks(bclient *client.BufferClient, idx *index.Index, note *index.Note, onlyExisting bool) error {
+	buff, err := bclient.NewBufferFromFile(idx.Abs(note), false)
+	if err != nil {
+		return err
+	}
+	if onlyExisting && !buff.HasBacklinks() {
+		return nil
+	}
+
+	names := []string{}
+	for _, backlink := range idx.Backlinks(note) {
+		name := backlink.Source.Name()
+		if len(names) == 0 || names[len(names)-1] != name {
+			names = append(names, name)
+		}
+	}
+
+	if !buff.SetBacklinks(names) {
+		return nil
+	}
+	if err := bclient.SaveBuffer(buff); err != nil {
+		return err
+	}
+	fmt.Println(buff.Origin)
+	return nil
+}
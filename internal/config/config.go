@@ -1,8 +1,44 @@
 package config
 
+import "time"
+
 var (
-	DefaultQuestionsFolder   = "/questions"
-	DefaultLiteraturesFolder = "/literatures"
-	DefaultMeetingsFolder    = "/meetings"
-	DefaultPermanentFolder   = "/permanent"
+	DefaultQuestionsFolder    = "/questions"
+	DefaultLiteraturesFolder  = "/literatures"
+	DefaultMeetingsFolder     = "/meetings"
+	DefaultPermanentFolder    = "/permanent"
+	DefaultTemplatesFolder    = "/.soa/templates"
+	DefaultAttachementsFolder = "/attachements"
+	DefaultIndexFile          = "/.soa/index.json"
+	DefaultSearchIndexFile    = "/.soa/search.gob"
+	DefaultLockFile           = "/.soa/lock"
+)
+
+var (
+	// Name of the optional config file, without extension, under vault-dir
+	ConfigFileName = ".soa"
+
+	// Template of the literature note filenames, see util.LiteratureFilenameData
+	// for the available fields.
+	DefaultLiteratureFilenameTemplate = "L {{.CitationKey}}.md"
+
+	// Data directory of zotero relative to the home directory, rendered
+	// annotation images are under its cache/library folder.
+	DefaultZoteroDataDir = "Zotero"
+
+	// How long to wait for another soa process to release the vault lock.
+	DefaultLockTimeout = 10 * time.Second
+)
+
+// Keys of the configuration values, settable from the config file.
+const (
+	KeyLiteratureFilenameTemplate = "literature-filename-template"
+	KeyLiteratureGroupBy          = "literature-group-by"
+	KeyColors                     = "colors"
+	KeyZoteroDataDir              = "zotero-data-dir"
+	KeyFrontMatter                = "front-matter"
+	KeyBackups                    = "backups"
+	KeyOnConflict                 = "on-conflict"
+	KeyLockTimeout                = "lock-timeout"
+	KeyNoLock                     = "no-lock"
 )
@@ -22,15 +22,26 @@ func (ct Date) MarshalYAML() (interface{}, error) {
 
 func (ct *Date) UnmarshalYAML(value *yaml.Node) error {
 	t, err := time.Parse(customDateFormat, value.Value)
-	if err != nil {
+	if err != nil && value.Decode(&t) != nil { // other yaml timestamps
 		return err
 	}
 	ct.Time = t
 	return nil
 }
 
+// Parses the given string as Date.
+func ParseDate(s string) (Date, error) {
+	t, err := time.Parse(customDateFormat, s)
+	if err != nil {
+		return Date{}, err
+	}
+	return Date{t}, nil
+}
+
 const customDateTimeFormat = "2006-01-02 15:04:05"
 
+// Date and time without a zone, such as the start of a meeting. It is read and
+// written in local time.
 type DateTime struct {
 	time.Time
 }
@@ -44,14 +55,32 @@ func (ct DateTime) MarshalYAML() (interface{}, error) {
 }
 
 func (ct *DateTime) UnmarshalYAML(value *yaml.Node) error {
-	t, err := time.Parse(customDateTimeFormat, value.Value)
-	if err != nil {
+	parsed, err := ParseDateTime(value.Value)
+	if err == nil {
+		*ct = parsed
+		return nil
+	}
+	var t time.Time
+	if value.Decode(&t) != nil { // other yaml timestamps
 		return err
 	}
 	ct.Time = t
 	return nil
 }
 
+// Parses the given string as DateTime in local time, the seconds can be
+// omitted.
+func ParseDateTime(s string) (DateTime, error) {
+	t, err := time.ParseInLocation(customDateTimeFormat, s, time.Local)
+	if err != nil {
+		t, err = time.ParseInLocation(customDateTimeFormat[:len(customDateTimeFormat)-3], s, time.Local)
+	}
+	if err != nil {
+		return DateTime{}, err
+	}
+	return DateTime{t}, nil
+}
+
 func CurrentDateTime() DateTime {
 	return DateTime{time.Now()}
 }
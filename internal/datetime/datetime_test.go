@@ -0,0 +1,45 @@
+package datetime
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Start times given on the command line and read from headers are the same
+// instant.
+func TestDateTimeZone(t *testing.T) {
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.FixedZone("UTC+3", 3*60*60)
+
+	for _, raw := range []string{"2024-01-01 10:00:00", "2024-01-01 10:00"} {
+		parsed, err := ParseDateTime(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var header struct {
+			Start DateTime `yaml:"start"`
+		}
+		if err := yaml.Unmarshal([]byte("start: "+raw), &header); err != nil {
+			t.Fatal(err)
+		}
+		if !header.Start.Equal(parsed.Time) {
+			t.Errorf("%s: read %v, parsed %v", raw, header.Start.Time, parsed.Time)
+		}
+		if header.Start.String() != "2024-01-01 10:00:00" {
+			t.Errorf("%s: written as %s", raw, header.Start)
+		}
+	}
+
+	var header struct {
+		Start DateTime `yaml:"start"`
+	}
+	if err := yaml.Unmarshal([]byte("start: 2024-01-01T10:00:00Z"), &header); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC); !header.Start.Equal(want) {
+		t.Errorf("got %v, want %v", header.Start.Time, want)
+	}
+}
@@ -0,0 +1,72 @@
+package doctor
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ubombar/soa/internal/log"
+	"github.com/ubombar/soa/pkg/client"
+	"github.com/ubombar/soa/pkg/index"
+)
+
+func DoctorCmd() *cobra.Command {
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the vault for problems",
+		Long: "Report broken links, missing from targets and pdfs, orphan notes, notes of unknown kind and malformed headers. " +
+			"Exits with a non-zero status if any problem is found",
+		Args: doctorCmdArgs,
+		Run:  doctorCmd,
+	}
+	doctorCmd.Flags().StringArray("ignore", []string{}, "check to skip, can be given multiple times: malformed-header, unknown-kind, broken-link, missing-from, missing-pdf or orphan")
+
+	return doctorCmd
+}
+
+func doctorCmd(cmd *cobra.Command, args []string) {
+	logger := log.GlobalLogger
+	ignored, _ := cmd.Flags().GetStringArray("ignore")
+
+	checks := []index.Check{}
+	for _, check := range index.AllChecks {
+		if !slices.Contains(ignored, string(check)) {
+			checks = append(checks, check)
+		}
+	}
+
+	bclient, err := client.NewBufferClient(nil)
+	if err != nil {
+		logger.Fatalf("cannot create buffer client: %v.\n", err)
+		os.Exit(1)
+	}
+
+	idx, err := index.Open(bclient, viper.GetString("vault-dir"))
+	if err != nil {
+		logger.Fatalf("cannot open index: %v.\n", err)
+		os.Exit(1)
+	}
+
+	problems := idx.Diagnose(checks)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+
+	if len(problems) != 0 {
+		logger.Errorf("%d problems found in %d notes.\n", len(problems), len(idx.Notes))
+		os.Exit(1)
+	}
+}
+
+func doctorCmdArgs(cmd *cobra.Command, args []string) error {
+	ignored, _ := cmd.Flags().GetStringArray("ignore")
+	for _, check := range ignored {
+		if !slices.Contains(index.AllChecks, index.Check(check)) {
+			return fmt.Errorf("unknown check: %s", check)
+		}
+	}
+	return cobra.NoArgs(cmd, args)
+}
@@ -0,0 +1,60 @@
+package index
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ubombar/soa/internal/log"
+	"github.com/ubombar/soa/pkg/client"
+	vaultindex "github.com/ubombar/soa/pkg/index"
+)
+
+func IndexCmd() *cobra.Command {
+	indexCmd := &cobra.Command{
+		Use:     "index",
+		Aliases: []string{"i"},
+		Short:   "Update the vault index",
+		Long:    "Scan the notes under the soa directory and update the cached index",
+		Args:    indexCmdArgs,
+		Run:     indexCmd,
+	}
+	indexCmd.Flags().Bool("rebuild", false, "ignore the cached index and parse every note")
+
+	return indexCmd
+}
+
+func indexCmd(cmd *cobra.Command, args []string) {
+	logger := log.GlobalLogger
+	rebuild, _ := cmd.Flags().GetBool("rebuild")
+	vaultDir := viper.GetString("vault-dir")
+
+	bclient, err := client.NewBufferClient(nil)
+	if err != nil {
+		logger.Fatalf("cannot create buffer client: %v.\n", err)
+		os.Exit(1)
+	}
+
+	idx := vaultindex.Load(bclient, vaultDir)
+	if rebuild {
+		idx.Notes = map[string]*vaultindex.Note{}
+	}
+
+	changed, err := idx.Update()
+	if err != nil {
+		logger.Fatalf("cannot update index: %v.\n", err)
+		os.Exit(1)
+	}
+	if err := idx.Save(); err != nil {
+		logger.Fatalf("cannot save index: %v.\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%d notes indexed, %d changed\n", len(idx.Notes), changed)
+}
+
+func indexCmdArgs(cmd *cobra.Command, args []string) error {
+	return nil
+}
@@ -0,0 +1,200 @@
+package list
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ubombar/soa/internal/datetime"
+	"github.com/ubombar/soa/internal/log"
+	"github.com/ubombar/soa/pkg/client"
+	"github.com/ubombar/soa/pkg/index"
+)
+
+var ErrBadFilter = errors.New("filter should be in key=value form")
+
+func ListCmd() *cobra.Command {
+	listCmd := &cobra.Command{
+		Use:     "list [kind]",
+		Aliases: []string{"ls"},
+		Short:   "List notes",
+		Long:    "List the notes under the soa directory, filtered and sorted by their header fields",
+		Args:    listCmdArgs,
+		Run:     listCmd,
+	}
+	listCmd.Flags().StringArrayP("tag", "t", []string{}, "only list notes with the tag, can be given multiple times")
+	listCmd.Flags().StringP("from", "f", "", "only list notes with the given from header")
+	listCmd.Flags().StringArrayP("where", "w", []string{}, "only list notes whose header matches key=value, can be given multiple times")
+	listCmd.Flags().String("since", "", "only list notes created on or after the date, as YYYY-MM-DD")
+	listCmd.Flags().String("until", "", "only list notes created on or before the date, as YYYY-MM-DD")
+	listCmd.Flags().StringP("sort", "s", "path", "header key to sort by, path sorts by the file path")
+	listCmd.Flags().BoolP("reverse", "r", false, "reverse the sort order")
+	listCmd.Flags().String("format", "table", "output format: table, json or paths")
+
+	return listCmd
+}
+
+// Single header filter given as key=value.
+type filter struct {
+	key   string
+	value string
+}
+
+func listCmd(cmd *cobra.Command, args []string) {
+	logger := log.GlobalLogger
+	tags, _ := cmd.Flags().GetStringArray("tag")
+	from, _ := cmd.Flags().GetString("from")
+	wheres, _ := cmd.Flags().GetStringArray("where")
+	rawSince, _ := cmd.Flags().GetString("since")
+	rawUntil, _ := cmd.Flags().GetString("until")
+	sortKey, _ := cmd.Flags().GetString("sort")
+	reverse, _ := cmd.Flags().GetBool("reverse")
+	format, _ := cmd.Flags().GetString("format")
+
+	filters := []filter{}
+	if len(args) == 1 {
+		filters = append(filters, filter{key: "kind", value: args[0]})
+	}
+	for _, tag := range tags {
+		filters = append(filters, filter{key: "tags", value: tag})
+	}
+	if from != "" {
+		filters = append(filters, filter{key: "from", value: from})
+	}
+	for _, where := range wheres {
+		key, value, ok := strings.Cut(where, "=")
+		if !ok {
+			logger.Fatalf("cannot parse filter %q: %v.\n", where, ErrBadFilter)
+			os.Exit(1)
+		}
+		filters = append(filters, filter{key: key, value: value})
+	}
+
+	since, err := parseDateFlag(rawSince)
+	if err != nil {
+		logger.Fatalf("cannot parse since date: %v.\n", err)
+		os.Exit(1)
+	}
+	until, err := parseDateFlag(rawUntil)
+	if err != nil {
+		logger.Fatalf("cannot parse until date: %v.\n", err)
+		os.Exit(1)
+	}
+
+	bclient, err := client.NewBufferClient(nil)
+	if err != nil {
+		logger.Fatalf("cannot create buffer client: %v.\n", err)
+		os.Exit(1)
+	}
+
+	idx, err := index.Open(bclient, viper.GetString("vault-dir"))
+	if err != nil {
+		logger.Fatalf("cannot open index: %v.\n", err)
+		os.Exit(1)
+	}
+
+	notes := []*index.Note{}
+	for _, note := range idx.All() {
+		if !matchFilters(note, filters) {
+			continue
+		}
+		if since != nil || until != nil {
+			created, err := note.Created()
+			if err != nil || (since != nil && created.Before(since.Time)) || (until != nil && created.After(until.Time)) {
+				continue
+			}
+		}
+		notes = append(notes, note)
+	}
+
+	sort.SliceStable(notes, func(i, j int) bool {
+		if sortKey == "path" {
+			return notes[i].Path < notes[j].Path
+		}
+		return notes[i].HeaderString(sortKey) < notes[j].HeaderString(sortKey)
+	})
+	if reverse {
+		for i, j := 0, len(notes)-1; i < j; i, j = i+1, j-1 {
+			notes[i], notes[j] = notes[j], notes[i]
+		}
+	}
+
+	if err := printNotes(idx, notes, format); err != nil {
+		logger.Fatalf("cannot print notes: %v.\n", err)
+		os.Exit(1)
+	}
+}
+
+// Parses the date given as YYYY-MM-DD, returns nil if it is empty.
+func parseDateFlag(s string) (*datetime.Date, error) {
+	if s == "" {
+		return nil, nil
+	}
+	date, err := datetime.ParseDate(s)
+	if err != nil {
+		return nil, err
+	}
+	return &date, nil
+}
+
+func listCmdArgs(cmd *cobra.Command, args []string) error {
+	return cobra.MaximumNArgs(1)(cmd, args)
+}
+
+func matchFilters(note *index.Note, filters []filter) bool {
+	for _, f := range filters {
+		if !note.Match(f.key, f.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Entry of the json output.
+type listEntry struct {
+	Path    string         `json:"path"`
+	Kind    string         `json:"kind"`
+	Title   string         `json:"title"`
+	Created string         `json:"created"`
+	Header  map[string]any `json:"header"`
+}
+
+func printNotes(idx *index.Index, notes []*index.Note, format string) error {
+	switch format {
+	case "paths":
+		for _, note := range notes {
+			fmt.Println(idx.Abs(note))
+		}
+	case "json":
+		entries := make([]listEntry, 0, len(notes))
+		for _, note := range notes {
+			entries = append(entries, listEntry{
+				Path:    idx.Abs(note),
+				Kind:    note.Kind,
+				Title:   note.Title(),
+				Created: note.HeaderString("created"),
+				Header:  note.Header,
+			})
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CREATED\tKIND\tTITLE\tPATH")
+		for _, note := range notes {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", note.HeaderString("created"), note.Kind, note.Title(), note.Path)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+	return nil
+}
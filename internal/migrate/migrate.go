@@ -0,0 +1,130 @@
+package migrate
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ubombar/soa/internal/config"
+	"github.com/ubombar/soa/internal/log"
+	"github.com/ubombar/soa/pkg/client"
+	"github.com/ubombar/soa/pkg/index"
+)
+
+func MigrateCmd() *cobra.Command {
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate the vault",
+		Long:  "Convert the notes of the vault to another format",
+		Args:  migrateCmdArgs,
+		Run:   migrateCmd,
+	}
+
+	migrateFrontMatterCmd := &cobra.Command{
+		Use:   "front-matter",
+		Short: "Convert note headers to another front matter dialect",
+		Long: "Rewrite the header of every note in the given front matter dialect: soa (--), yaml (---) or toml (+++). " +
+			"Each converted note is read back first, notes whose header would change are skipped and reported",
+		Args: migrateFrontMatterCmdArgs,
+		Run:  migrateFrontMatterCmd,
+	}
+	migrateFrontMatterCmd.Flags().String("to", string(client.FrontMatterYAML), "front matter dialect to convert to: soa, yaml or toml")
+	migrateFrontMatterCmd.Flags().BoolP("dry-run", "n", false, "report the changes without writing them")
+
+	// add under migrate command
+	migrateCmd.AddCommand(migrateFrontMatterCmd)
+
+	return migrateCmd
+}
+
+func migrateCmd(cmd *cobra.Command, args []string) {
+	cmd.Help()
+}
+
+func migrateCmdArgs(cmd *cobra.Command, args []string) error {
+	return nil
+}
+
+func migrateFrontMatterCmd(cmd *cobra.Command, args []string) {
+	logger := log.GlobalLogger
+	rawTo, _ := cmd.Flags().GetString("to")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	to, err := client.ParseFrontMatter(rawTo)
+	if err != nil {
+		logger.Fatalf("cannot parse front matter: %v.\n", err)
+		os.Exit(1)
+	}
+
+	bclient, err := client.NewBufferClient(nil)
+	if err != nil {
+		logger.Fatalf("cannot create buffer client: %v.\n", err)
+		os.Exit(1)
+	}
+
+	idx, err := index.Open(bclient, viper.GetString("vault-dir"))
+	if err != nil {
+		logger.Fatalf("cannot open index: %v.\n", err)
+		os.Exit(1)
+	}
+
+	converted, unchanged, noHeader, failed := 0, 0, 0, 0
+	for _, note := range idx.All() {
+		if note.Error != "" {
+			fmt.Printf("%s: skipped: cannot parse header: %s\n", note.Path, note.Error)
+			failed++
+			continue
+		}
+
+		buff, err := bclient.NewBufferFromFile(idx.Abs(note), false)
+		if err != nil {
+			fmt.Printf("%s: skipped: %v\n", note.Path, err)
+			failed++
+			continue
+		}
+
+		from := buff.SourceFrontMatter()
+		switch from {
+		case "":
+			noHeader++
+			continue
+		case to:
+			unchanged++
+			continue
+		}
+
+		if _, err := buff.ConvertFrontMatter(to); err != nil {
+			fmt.Printf("%s: skipped: %v\n", note.Path, err)
+			failed++
+			continue
+		}
+		if !dryRun {
+			if err := bclient.SaveBuffer(buff); err != nil {
+				fmt.Printf("%s: failed: %v\n", note.Path, err)
+				failed++
+				continue
+			}
+		}
+		fmt.Printf("%s: %s -> %s\n", note.Path, from, to)
+		converted++
+	}
+
+	verb := "converted"
+	if dryRun {
+		verb = "to convert"
+	}
+	fmt.Printf("%d %s, %d already %s, %d without header, %d skipped.\n", converted, verb, unchanged, to, noHeader, failed)
+
+	if configured, _ := client.ParseFrontMatter(viper.GetString(config.KeyFrontMatter)); configured != to {
+		logger.Warnf("new notes are written as %s, set %s: %s in the vault config to keep using %s.\n", configured, config.KeyFrontMatter, to, to)
+	}
+	if failed != 0 {
+		os.Exit(1)
+	}
+}
+
+func migrateFrontMatterCmdArgs(cmd *cobra.Command, args []string) error {
+	return cobra.NoArgs(cmd, args)
+}
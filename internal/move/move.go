@@ -0,0 +1,179 @@
+package move
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ubombar/soa/internal/log"
+	"github.com/ubombar/soa/pkg/client"
+	"github.com/ubombar/soa/pkg/diff"
+	"github.com/ubombar/soa/pkg/index"
+)
+
+func MoveCmd() *cobra.Command {
+	moveCmd := &cobra.Command{
+		Use:   "mv <note> <new>",
+		Short: "Move or rename a note",
+		Long: "Move or rename a note and rewrite the links of the other notes pointing to it. " +
+			"The new path is relative to the vault, a plain name keeps the note in its folder and a path ending with / moves it into that folder. " +
+			"If the new name follows the naming of the note kind, the title header is updated too",
+		Args: moveCmdArgs,
+		Run:  moveCmd,
+	}
+	moveCmd.Flags().BoolP("dry-run", "n", false, "print the changes as a diff without writing them")
+
+	return moveCmd
+}
+
+func RetitleCmd() *cobra.Command {
+	retitleCmd := &cobra.Command{
+		Use:   "retitle <note> <title>",
+		Short: "Change the title of a note",
+		Long:  "Change the title header of a note, rename it after the new title and rewrite the links of the other notes pointing to it",
+		Args:  retitleCmdArgs,
+		Run:   retitleCmd,
+	}
+	retitleCmd.Flags().BoolP("dry-run", "n", false, "print the changes as a diff without writing them")
+
+	return retitleCmd
+}
+
+func moveCmd(cmd *cobra.Command, args []string) {
+	logger := log.GlobalLogger
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	idx, note := openNote(args[0])
+
+	newPath, err := targetPath(note, args[1])
+	if err != nil {
+		logger.Fatalf("cannot move %s: %v.\n", note.Path, err)
+		os.Exit(1)
+	}
+	title, _ := index.TitleFromFilename(note.Kind, path.Base(newPath))
+
+	edits, err := idx.Move(note, newPath, title)
+	if err != nil {
+		logger.Fatalf("cannot move %s: %v.\n", note.Path, err)
+		os.Exit(1)
+	}
+	applyEdits(idx, edits, dryRun)
+}
+
+func retitleCmd(cmd *cobra.Command, args []string) {
+	logger := log.GlobalLogger
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	idx, note := openNote(args[0])
+
+	title := strings.Join(args[1:], " ")
+	edits, err := idx.Retitle(note, title)
+	if err != nil {
+		logger.Fatalf("cannot retitle %s: %v.\n", note.Path, err)
+		os.Exit(1)
+	}
+	applyEdits(idx, edits, dryRun)
+}
+
+func moveCmdArgs(cmd *cobra.Command, args []string) error {
+	return cobra.ExactArgs(2)(cmd, args)
+}
+
+func retitleCmdArgs(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		return errors.New("note or title is not given")
+	}
+	return nil
+}
+
+func openNote(ref string) (*index.Index, *index.Note) {
+	logger := log.GlobalLogger
+
+	bclient, err := client.NewBufferClient(nil)
+	if err != nil {
+		logger.Fatalf("cannot create buffer client: %v.\n", err)
+		os.Exit(1)
+	}
+
+	idx, err := index.Open(bclient, viper.GetString("vault-dir"))
+	if err != nil {
+		logger.Fatalf("cannot open index: %v.\n", err)
+		os.Exit(1)
+	}
+
+	note, err := idx.Find(ref)
+	if err != nil {
+		logger.Fatalf("cannot find %s: %v.\n", ref, err)
+		os.Exit(1)
+	}
+	return idx, note
+}
+
+// Returns the new path of the note relative to the vault.
+func targetPath(note *index.Note, arg string) (string, error) {
+	vaultDir, err := filepath.Abs(viper.GetString("vault-dir"))
+	if err != nil {
+		return "", err
+	}
+	if filepath.IsAbs(arg) {
+		rel, err := filepath.Rel(vaultDir, arg)
+		if err != nil {
+			return "", err
+		}
+		if strings.HasSuffix(arg, "/") {
+			rel += "/"
+		}
+		arg = filepath.ToSlash(rel)
+	}
+
+	var newPath string
+	info, err := os.Stat(filepath.Join(vaultDir, filepath.FromSlash(arg)))
+	switch {
+	case strings.HasSuffix(arg, "/") || (err == nil && info.IsDir()):
+		newPath = path.Join(arg, path.Base(note.Path))
+	case strings.Contains(arg, "/"):
+		newPath = arg
+	default:
+		newPath = path.Join(path.Dir(note.Path), arg)
+	}
+	// the title is read from the filename, which needs the extension
+	if path.Ext(newPath) != ".md" {
+		newPath += ".md"
+	}
+	return newPath, nil
+}
+
+// Prints the edits as a diff if dryRun is set, writes them otherwise.
+func applyEdits(idx *index.Index, edits []index.Edit, dryRun bool) {
+	logger := log.GlobalLogger
+
+	if dryRun {
+		for _, edit := range edits {
+			updated, err := edit.Buffer.Bytes()
+			if err != nil {
+				logger.Fatalf("cannot render %s: %v.\n", edit.NewPath, err)
+				os.Exit(1)
+			}
+			fmt.Print(diff.Unified("a/"+edit.Path, "b/"+edit.NewPath, string(edit.Old), string(updated)))
+		}
+		return
+	}
+
+	if err := idx.Apply(edits); err != nil {
+		logger.Fatalf("cannot write changes: %v.\n", err)
+		os.Exit(1)
+	}
+	for _, edit := range edits {
+		if edit.NewPath != edit.Path {
+			fmt.Printf("%s -> %s\n", edit.Path, edit.NewPath)
+		} else {
+			fmt.Println(edit.Path)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package move
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/ubombar/soa/pkg/index"
+)
+
+func TestTargetPath(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "archive"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("vault-dir", dir)
+	defer viper.Set("vault-dir", nil)
+
+	note := &index.Note{Path: "notes/P 20240101120000-abcd Old title.md", Kind: "permanent"}
+
+	tests := []struct {
+		arg   string
+		want  string
+		title string
+	}{
+		{"P 20240101120000-abcd New title", "notes/P 20240101120000-abcd New title.md", "New title"},
+		{"P 20240101120000-abcd New title.md", "notes/P 20240101120000-abcd New title.md", "New title"},
+		{"P 20240101120000-abcd Version 1.2", "notes/P 20240101120000-abcd Version 1.2.md", "Version 1.2"},
+		{"other/P 20240101120000-abcd Moved", "other/P 20240101120000-abcd Moved.md", "Moved"},
+		{"archive", "archive/P 20240101120000-abcd Old title.md", "Old title"},
+		{"new/", "new/P 20240101120000-abcd Old title.md", "Old title"},
+		{filepath.Join(dir, "archive") + "/", "archive/P 20240101120000-abcd Old title.md", "Old title"},
+		{"free name", "notes/free name.md", ""},
+	}
+	for _, test := range tests {
+		got, err := targetPath(note, test.arg)
+		if err != nil {
+			t.Fatalf("%s: %v", test.arg, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.arg, got, test.want)
+		}
+		if title, _ := index.TitleFromFilename(note.Kind, path.Base(got)); title != test.title {
+			t.Errorf("%s: got title %q, want %q", test.arg, title, test.title)
+		}
+	}
+}
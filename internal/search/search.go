@@ -0,0 +1,120 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ubombar/soa/internal/log"
+	"github.com/ubombar/soa/pkg/client"
+	"github.com/ubombar/soa/pkg/index"
+	"github.com/ubombar/soa/pkg/search"
+)
+
+func SearchCmd() *cobra.Command {
+	searchCmd := &cobra.Command{
+		Use:     "search <query>",
+		Aliases: []string{"f"},
+		Short:   "Search notes",
+		Long:    "Search the contents and headers of the notes. Quoted text is searched as a phrase and key:value terms such as kind:question or tag:networking match header fields",
+		Args:    searchCmdArgs,
+		Run:     searchCmd,
+	}
+	searchCmd.Flags().IntP("limit", "n", 20, "maximum number of results, 0 for all")
+	searchCmd.Flags().String("format", "text", "output format: text, json or paths")
+
+	return searchCmd
+}
+
+func searchCmd(cmd *cobra.Command, args []string) {
+	logger := log.GlobalLogger
+	limit, _ := cmd.Flags().GetInt("limit")
+	format, _ := cmd.Flags().GetString("format")
+	vaultDir := viper.GetString("vault-dir")
+	query := search.ParseQuery(strings.Join(args, " "))
+
+	bclient, err := client.NewBufferClient(nil)
+	if err != nil {
+		logger.Fatalf("cannot create buffer client: %v.\n", err)
+		os.Exit(1)
+	}
+
+	idx, err := index.Open(bclient, vaultDir)
+	if err != nil {
+		logger.Fatalf("cannot open index: %v.\n", err)
+		os.Exit(1)
+	}
+
+	searchIndex, err := search.Open(bclient, idx, vaultDir)
+	if err != nil {
+		logger.Fatalf("cannot open search index: %v.\n", err)
+		os.Exit(1)
+	}
+
+	// highlight with bold text on terminals, markdown otherwise
+	highlightStart, highlightEnd := "**", "**"
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		highlightStart, highlightEnd = "\033[1m", "\033[0m"
+	}
+
+	results, err := searchIndex.Search(query, limit, highlightStart, highlightEnd)
+	if err != nil {
+		logger.Fatalf("cannot search: %v.\n", err)
+		os.Exit(1)
+	}
+
+	if err := printResults(idx, results, format); err != nil {
+		logger.Fatalf("cannot print results: %v.\n", err)
+		os.Exit(1)
+	}
+}
+
+func searchCmdArgs(cmd *cobra.Command, args []string) error {
+	return cobra.MinimumNArgs(1)(cmd, args)
+}
+
+// Entry of the json output.
+type searchEntry struct {
+	Path    string  `json:"path"`
+	Kind    string  `json:"kind"`
+	Title   string  `json:"title"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+func printResults(idx *index.Index, results []search.Result, format string) error {
+	switch format {
+	case "paths":
+		for _, result := range results {
+			fmt.Println(idx.Abs(result.Note))
+		}
+	case "json":
+		entries := make([]searchEntry, 0, len(results))
+		for _, result := range results {
+			entries = append(entries, searchEntry{
+				Path:    idx.Abs(result.Note),
+				Kind:    result.Note.Kind,
+				Title:   result.Note.Title(),
+				Score:   result.Score,
+				Snippet: result.Snippet,
+			})
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	case "text":
+		for _, result := range results {
+			fmt.Printf("%s (%s, %.2f)\n", result.Note.Path, result.Note.Title(), result.Score)
+			if result.Snippet != "" {
+				fmt.Printf("    %s\n", result.Snippet)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+	return nil
+}
@@ -1,13 +1,13 @@
 package sync
 
 import (
-	"errors"
 	"fmt"
 	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/ubombar/soa/internal/config"
 	"github.com/ubombar/soa/internal/log"
 	"github.com/ubombar/soa/pkg/client"
 )
@@ -30,6 +30,9 @@ func SyncCmd() *cobra.Command {
 		Args:    syncLiteratureCmdArgs,
 		Run:     syncLiteratureCmd,
 	}
+	addLiteratureCmd.Flags().StringP("attachements", "a", string(client.AttachementPolicyFirst), "attachement policy: first (first pdf), merge (all attachements) or none (metadata only)")
+	addLiteratureCmd.Flags().StringP("group-by", "g", "", "group annotations: none or color, defaults to literature-group-by config")
+	addLiteratureCmd.Flags().String("on-conflict", "", "when the note changed since it was read: fail, merge or overwrite, defaults to on-conflict config")
 
 	// add under add command
 	syncCmd.AddCommand(addLiteratureCmd)
@@ -51,6 +54,21 @@ func syncCmdArgs(cmd *cobra.Command, args []string) error {
 // literature
 func syncLiteratureCmd(cmd *cobra.Command, args []string) {
 	logger := log.GlobalLogger
+	rawPolicy, _ := cmd.Flags().GetString("attachements")
+	policy, err := client.ParseAttachementPolicy(rawPolicy)
+	if err != nil {
+		logger.Fatalf("error on parsing attachement policy: %v.\n", err)
+		os.Exit(1)
+	}
+	if cmd.Flags().Changed("group-by") { // flag takes precedence over the config file
+		groupBy, _ := cmd.Flags().GetString("group-by")
+		viper.Set(config.KeyLiteratureGroupBy, groupBy)
+	}
+	if cmd.Flags().Changed("on-conflict") {
+		onConflict, _ := cmd.Flags().GetString("on-conflict")
+		viper.Set(config.KeyOnConflict, onConflict)
+	}
+
 	zclient, err := client.NewZoteroClient(nil)
 	if err != nil {
 		logger.Fatalf("error on creating zotero client: %v.\n", err)
@@ -68,29 +86,23 @@ func syncLiteratureCmd(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
+	// failures are reported at the end so one entry does not abort the others
+	failures := []error{}
 	for _, entry := range selectedEntries {
-		citationKey := entry.CitationKey // if this is not available just shit yourself
-
-		attachements, err := zclient.GetAttachements(citationKey)
+		buff, err := bclient.SyncLiterature(zclient, &entry, policy, true)
 		if err != nil {
-			logger.Fatalf("error on selecting zotero entries: %v.\n", err)
-			os.Exit(1)
+			failures = append(failures, fmt.Errorf("%s: %w", entry.CitationKey, err))
+			continue
 		}
+		fmt.Printf("%s\n", buff.Origin) // print the filepath to stdout
+	}
 
-		// get the first pdf attachement we found
-		if len(attachements) != 1 {
-			logger.Fatalf("error on retrieving attachements: %v.\n", errors.New("zero or more than one pdf attachements"))
-			os.Exit(1)
-		}
-
-		attachement := attachements[0] // get the first element, again we don't expect multiple pdfs
-
-		buff, err := bclient.NewLiterature(&entry, &attachement, true)
-		if err != nil {
-			logger.Fatalf("error on saving literature note: %v.\n", err)
-			os.Exit(1)
+	if len(failures) != 0 {
+		for _, err := range failures {
+			logger.Errorf("error on syncing %v.\n", err)
 		}
-		fmt.Printf("%s\n", buff.Origin) // print the filepath to stdout
+		logger.Fatalf("%d of %d literature notes failed to sync.\n", len(failures), len(selectedEntries))
+		os.Exit(1)
 	}
 }
 
@@ -3,11 +3,16 @@ package util
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"text/template"
+	"time"
 	"unicode/utf8"
 
+	"github.com/dchest/uniuri"
 	"github.com/spf13/viper"
 
 	"github.com/ubombar/soa/internal/datetime"
@@ -39,18 +44,186 @@ func GetFilename(dir string, filename string) string {
 	return filepath.Join(vaultDir, dir, filename)
 }
 
+// Replaces the leading ~ of the path with the home directory.
+func ExpandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
 func FileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil || !os.IsNotExist(err)
 }
 
-func LiteratureFilename(pdfPath string, date datetime.Date) string {
-	baseName := filepath.Base(pdfPath)
-	noteName := fmt.Sprintf("L %s %s.md", date.String(), baseName)
-	return noteName
+// Fields that can be used in the literature filename template.
+type LiteratureFilenameData struct {
+	CitationKey string // citation key of the item, stable across syncs
+	Title       string // title of the item
+	ShortTitle  string // short title of the item, might be empty
+	Year        string // publication year of the item, might be empty
+	PDF         string // base name of the pdf attachment, might be empty
+	Date        string // current date
+}
+
+func LiteratureFilename(tmpl string, data LiteratureFilenameData) (string, error) {
+	t, err := template.New("literature-filename").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+var yearRegexp = regexp.MustCompile(`\b\d{4}\b`)
+
+// Extracts the year from the free form date field of zotero items such as
+// "August 1, 2014" or "2014-08-01". Returns empty string if there is none.
+func ExtractYear(date string) string {
+	return yearRegexp.FindString(date)
 }
 
 func QuestionFilename(title string, date datetime.Date) string {
 	noteName := fmt.Sprintf("Q %s %s.md", date.String(), title)
 	return noteName
 }
+
+func MeetingFilename(title string, date datetime.Date) string {
+	noteName := fmt.Sprintf("M %s %s.md", date.String(), title)
+	return noteName
+}
+
+func PermanentFilename(id string, title string) string {
+	noteName := fmt.Sprintf("P %s %s.md", id, title)
+	return noteName
+}
+
+const (
+	zettelIDFormat     = "20060102150405"
+	zettelSuffixLength = 4
+)
+
+var zettelSuffixChars = []byte("abcdefghijklmnopqrstuvwxyz0123456789")
+
+// Returns a timestamp-style zettel id such as 20250418153012-x7k2. The random
+// suffix keeps ids unique when multiple notes are created in the same second.
+func NewZettelID(t time.Time) string {
+	return fmt.Sprintf("%s-%s", t.Format(zettelIDFormat), uniuri.NewLenChars(zettelSuffixLength, zettelSuffixChars))
+}
+
+// Writes the file through a temporary file in the same directory that is
+// synced and renamed into place, so the file is either the old or the new one
+// even if the write fails midway. The mode of an existing file is kept and
+// symlinks are written through. If backups is positive the previous contents
+// are kept as path.bak, path.bak.1 and so on, the oldest ones are dropped.
+func WriteFileAtomic(path string, write func(w io.Writer) error, backups int) error {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	mode := os.FileMode(0644)
+	info, err := os.Stat(path)
+	exists := err == nil
+	if exists {
+		mode = info.Mode().Perm()
+	}
+
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".*.tmp")
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if exists && backups > 0 {
+		if err := rotateBackups(path, backups); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	committed = true
+
+	// make the rename itself durable, not supported on every platform
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Shifts the backups of the file by one and keeps its current contents as
+// path.bak.
+func rotateBackups(path string, backups int) error {
+	name := func(i int) string {
+		if i == 0 {
+			return path + ".bak"
+		}
+		return fmt.Sprintf("%s.bak.%d", path, i)
+	}
+
+	for i := backups - 1; i > 0; i-- {
+		if err := os.Rename(name(i-1), name(i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	os.Remove(name(0))
+
+	// a hard link keeps the old contents once the new file is renamed over it
+	if err := os.Link(path, name(0)); err == nil {
+		return nil
+	}
+	return CopyFile(path, name(0))
+}
+
+// Copies the contents of src into dst, dst is created or truncated.
+func CopyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,169 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeString(s string) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Returns the names of the files in the directory.
+func listDir(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "note.md")
+
+	if err := WriteFileAtomic(path, writeString("first"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "first" {
+		t.Fatalf("got %q", got)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Fatalf("new file has mode %v", info.Mode().Perm())
+	}
+
+	// the mode of an existing file is kept
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(path, writeString("second"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "second" {
+		t.Fatalf("got %q", got)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("mode is not kept: %v %v", info.Mode().Perm(), err)
+	}
+
+	// a failing write leaves the file and no temporary file behind
+	failed := errors.New("failed")
+	err = WriteFileAtomic(path, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return failed
+	}, 0)
+	if !errors.Is(err, failed) {
+		t.Fatalf("got %v", err)
+	}
+	if got := readFile(t, path); got != "second" {
+		t.Fatalf("failed write changed the file: %q", got)
+	}
+	if names := listDir(t, dir); len(names) != 1 {
+		t.Fatalf("files are left: %v", names)
+	}
+}
+
+func TestWriteFileAtomicSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.md")
+	link := filepath.Join(dir, "link.md")
+	if err := os.WriteFile(target, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Skip(err)
+	}
+
+	if err := WriteFileAtomic(link, writeString("new"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("symlink is replaced: %v", err)
+	}
+	if got := readFile(t, target); got != "new" {
+		t.Fatalf("target holds %q", got)
+	}
+	if got := readFile(t, target+".bak"); got != "old" {
+		t.Fatalf("backup of the target holds %q", got)
+	}
+}
+
+func TestRotateBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "note.md")
+
+	for i := 0; i < 5; i++ {
+		if err := WriteFileAtomic(path, writeString(fmt.Sprint(i)), 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]string{
+		"note.md":       "4",
+		"note.md.bak":   "3",
+		"note.md.bak.1": "2",
+		"note.md.bak.2": "1",
+	}
+	names := listDir(t, dir)
+	if len(names) != len(want) {
+		t.Fatalf("got files %v", names)
+	}
+	for name, contents := range want {
+		if got := readFile(t, filepath.Join(dir, name)); got != contents {
+			t.Errorf("%s holds %q, want %q", name, got, contents)
+		}
+	}
+}
+
+// Backups are copied on file systems without hard links.
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := os.WriteFile(src, []byte("contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, []byte("longer old contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CopyFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, dst); got != "contents" {
+		t.Fatalf("got %q", got)
+	}
+	if err := os.WriteFile(src, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, dst); got != "contents" {
+		t.Fatalf("copy follows the source: %q", got)
+	}
+	if err := CopyFile(filepath.Join(dir, "missing"), dst); !os.IsNotExist(err) {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ubombar/soa/internal/log"
+	"github.com/ubombar/soa/pkg/client"
+	"github.com/ubombar/soa/pkg/index"
+)
+
+func ValidateCmd() *cobra.Command {
+	validateCmd := &cobra.Command{
+		Use:   "validate [kind]",
+		Short: "Validate note headers",
+		Long: "Check the header of every note against the header fields of its kind and report the fields whose values do not fit their type. " +
+			"Exits with a non-zero status if any header is invalid",
+		Args: validateCmdArgs,
+		Run:  validateCmd,
+	}
+
+	return validateCmd
+}
+
+func validateCmd(cmd *cobra.Command, args []string) {
+	logger := log.GlobalLogger
+
+	bclient, err := client.NewBufferClient(nil)
+	if err != nil {
+		logger.Fatalf("cannot create buffer client: %v.\n", err)
+		os.Exit(1)
+	}
+
+	idx, err := index.Open(bclient, viper.GetString("vault-dir"))
+	if err != nil {
+		logger.Fatalf("cannot open index: %v.\n", err)
+		os.Exit(1)
+	}
+
+	invalid := 0
+	checked := 0
+	for _, note := range idx.All() {
+		if len(args) == 1 && note.Kind != args[0] {
+			continue
+		}
+		checked++
+		if note.Error != "" {
+			fmt.Printf("%s: cannot parse header: %s\n", note.Path, note.Error)
+			invalid++
+			continue
+		}
+
+		buff, err := bclient.NewBufferFromFile(idx.Abs(note), false)
+		if err != nil {
+			logger.Fatalf("cannot read %s: %v.\n", note.Path, err)
+			os.Exit(1)
+		}
+
+		var headerErr *client.HeaderError
+		if err := client.ValidateHeader(buff); errors.As(err, &headerErr) {
+			for _, field := range headerErr.Fields {
+				fmt.Printf("%s: %s: %v\n", note.Path, note.Kind, field)
+			}
+			invalid++
+		} else if err != nil {
+			logger.Fatalf("cannot validate %s: %v.\n", note.Path, err)
+			os.Exit(1)
+		}
+	}
+
+	if invalid != 0 {
+		logger.Errorf("%d of %d notes have invalid headers.\n", invalid, checked)
+		os.Exit(1)
+	}
+}
+
+func validateCmdArgs(cmd *cobra.Command, args []string) error {
+	return cobra.MaximumNArgs(1)(cmd, args)
+}
@@ -3,8 +3,12 @@ package client
 import (
 	"bytes"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 
 	"github.com/ubombar/soa/api"
+	"github.com/ubombar/soa/internal/util"
 )
 
 func generateQuestionContent() (*bytes.Buffer, error) {
@@ -12,91 +16,247 @@ func generateQuestionContent() (*bytes.Buffer, error) {
 	return b, nil
 }
 
-func generateLiteratureContent(attach *api.ZoteroAttachementItem) (*bytes.Buffer, error) {
-	b := bytes.NewBufferString("`this file is autogenerated`\n\n")
-
-	for _, annot := range attach.Annotations {
-		switch annot.AnnotationType {
-		case api.Highlight:
-			if err := generateLiteratureHighlight(annot, b); err != nil {
-				return nil, err
-			}
-		case api.Ink:
-			// cannot annotate that in markdown
-		case api.Text:
-			// no markdown for plain text
-		case api.Note:
-			if err := generateLiteratureNote(annot, b); err != nil {
-				return nil, err
-			}
-		case api.Image:
-			// cannot anotate that either
-		case api.Underline:
-			if err := generateLiteratureUnderline(annot, b); err != nil {
-				return nil, err
-			}
-		}
+func generateMeetingContent(header *api.MeetingHeader) (*bytes.Buffer, error) {
+	b := bytes.NewBufferString("\n## Agenda\n\n- \n")
+	b.WriteString("\n## Discussion\n\n")
+	b.WriteString("\n## Decisions\n\n")
+	b.WriteString("\n## Action Items\n\n")
+	if len(header.Attendees) == 0 {
+		b.WriteString("- [ ] \n")
+	}
+	for _, attendee := range header.Attendees {
+		fmt.Fprintf(b, "- [ ] %s: \n", attendee)
 	}
+	return b, nil
+}
 
+func generatePermanentContent(header *api.PermanentHeader) (*bytes.Buffer, error) {
+	b := bytes.NewBufferString("\n")
+	fmt.Fprintf(b, "# %s\n\n", header.Title)
 	return b, nil
 }
 
-var ColorToIcon = map[api.AnnotationColor]string{
-	api.ColorYellow:  "🟨",
-	api.ColorRed:     "🟥",
-	api.ColorGreen:   "🟩",
-	api.ColorBlue:    "🟦",
-	api.ColorPurple:  "🟪",
-	api.ColorMagenta: "❓",
-	api.ColorOrage:   "❓",
-	api.ColorGray:    "❓",
+// Populates the literature header from the zotero item. Tags added by hand are
+// kept, zotero tags are appended to them.
+func generateLiteratureHeader(header *api.LiteratureHeader, entry *api.ZoteroCitationEntry) {
+	item := &entry.Item
+
+	header.CitationKey = entry.CitationKey
+	header.ZoteroKey = item.ItemKey
+	header.Title = item.Title
+	header.Authors = zoteroAuthors(item.Creators)
+	header.Year = util.ExtractYear(item.Date)
+	header.Date = item.Date
+	header.ItemType = item.ItemType
+	header.Venue = firstNonEmpty(item.PublicationTitle, item.ProceedingsTitle, item.ConferenceName, item.BookTitle, item.Publisher)
+	header.Volume = item.Volume
+	header.Issue = item.Issue
+	header.Pages = item.Pages
+	header.DOI = item.DOI
+	header.URL = item.URL
+	header.Abstract = item.AbstractNote
+	header.Collections = item.Collections
+	if header.Collections == nil {
+		header.Collections = []string{}
+	}
+
+	// tags removed in zotero since the last sync are dropped, hand added
+	// tags are kept
+	tags := mergeTags(zoteroTags(item.Tags))
+	header.Tags = mergeTags(withoutTags(header.Tags, withoutTags(header.ZoteroTags, tags)), tags)
+	header.ZoteroTags = tags
+}
+
+// Returns the authors as "Last, First", falls back to all creators if there
+// is no creator with the author type.
+func zoteroAuthors(creators []api.ZoteroCreator) []string {
+	authors := []string{}
+	all := []string{}
+	for _, creator := range creators {
+		name := creator.Name
+		if name == "" {
+			name = strings.TrimSuffix(creator.LastName+", "+creator.FirstName, ", ")
+		}
+		if creator.CreatorType == "author" {
+			authors = append(authors, name)
+		}
+		all = append(all, name)
+	}
+	if len(authors) == 0 {
+		return all
+	}
+	return authors
 }
 
-func generateLiteratureHighlight(annot api.ZoteroAnnotation, b *bytes.Buffer) error {
-	comment := fmt.Sprintf("%s\n", annot.AnnotationComment)
-	if annot.AnnotationComment == "" {
-		comment = ""
+// Zotero tags are either plain strings or objects like {"tag": "x", "type": 1}.
+// They are lower cased and spaces are replaced with dashes to be used as note
+// tags.
+func zoteroTags(rawTags []interface{}) []string {
+	tags := []string{}
+	for _, rawTag := range rawTags {
+		var tag string
+		switch t := rawTag.(type) {
+		case string:
+			tag = t
+		case map[string]interface{}:
+			tag, _ = t["tag"].(string)
+		}
+		tag = strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+		if tag != "" {
+			tags = append(tags, tag)
+		}
 	}
-	text := fmt.Sprintf("highlight %s(p.%s[%d]):\n`%s`\n%s\n",
-		ColorToIcon[annot.AnnotationColor],
-		annot.AnnotationPageLabel,
-		annot.AnnotationPosition.PageIndex,
-		annot.AnnotationText,
-		comment)
+	return tags
+}
 
-	b.WriteString(text)
-	return nil
+// Returns the union of the tags, preserving the order of their first
+// occurrence.
+func mergeTags(tags ...[]string) []string {
+	seen := map[string]bool{}
+	merged := []string{}
+	for _, list := range tags {
+		for _, tag := range list {
+			if seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			merged = append(merged, tag)
+		}
+	}
+	return merged
 }
 
-func generateLiteratureNote(annot api.ZoteroAnnotation, b *bytes.Buffer) error {
-	comment := fmt.Sprintf("%s\n", annot.AnnotationComment)
-	if annot.AnnotationComment == "" {
-		comment = ""
+// Returns the tags that are not in removed.
+func withoutTags(tags []string, removed []string) []string {
+	drop := map[string]bool{}
+	for _, tag := range removed {
+		drop[tag] = true
 	}
-	text := fmt.Sprintf("note %s(p.%s[%d]):\n\n%s\n",
-		ColorToIcon[annot.AnnotationColor],
-		annot.AnnotationPageLabel,
-		annot.AnnotationPosition.PageIndex,
-		comment)
+	kept := []string{}
+	for _, tag := range tags {
+		if !drop[tag] {
+			kept = append(kept, tag)
+		}
+	}
+	return kept
+}
 
-	b.WriteString(text)
-	return nil
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
 }
 
-func generateLiteratureUnderline(annot api.ZoteroAnnotation, b *bytes.Buffer) error {
-	comment := fmt.Sprintf("%s\n", annot.AnnotationComment)
-	if annot.AnnotationComment == "" {
-		comment = ""
+// Regenerates the autogenerated region of the literature content. Annotations
+// that are not modified since the last sync are kept as they are, everything
+// outside of the region is left untouched.
+func generateLiteratureContent(content *bytes.Buffer, attachements []api.ZoteroAttachementItem, renderers AnnotationRenderers, scheme api.ColorScheme, groupBy LiteratureGroupBy) (*bytes.Buffer, error) {
+	existing := content.Bytes()
+
+	// notes generated before the region markers start with the generated
+	// annotations, they are replaced by an empty region and the text below
+	// them is kept
+	if n := legacyAutogenLength(existing); n != 0 {
+		rest := bytes.TrimLeft(existing[n:], "\n")
+		migrated := new(bytes.Buffer)
+		migrated.WriteString("\n" + autogenBeginMarker + "\n" + autogenEndMarker + "\n")
+		if len(rest) != 0 {
+			migrated.WriteString("\n")
+			migrated.Write(rest)
+		}
+		existing = migrated.Bytes()
+	}
+
+	previous := parseAnnotationBlocks(getRegion(existing, autogenBeginMarker, autogenEndMarker))
+
+	// annotations are ordered by their position within each attachement
+	annots := []api.ZoteroAnnotation{}
+	for _, attachement := range attachements {
+		sorted := slices.Clone(attachement.Annotations)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].AnnotationSortIndex < sorted[j].AnnotationSortIndex
+		})
+		annots = append(annots, sorted...)
+	}
+
+	rendered := make([][]byte, len(annots))
+	for i, annot := range annots {
+		renderer, hasRenderer := renderers[annot.AnnotationType]
+
+		// blocks written before zotero rendered the image are rendered again
+		exported := true
+		if r, ok := renderer.(exportingRenderer); ok {
+			exported = r.Exported(annot)
+		}
+		if block, ok := previous[annot.Key]; ok && exported && !annot.DateModified.After(block.Modified) {
+			rendered[i] = block.Text
+			continue
+		}
+
+		if !hasRenderer {
+			continue // nothing to render for this annotation type
+		}
+
+		annotBuffer := new(bytes.Buffer)
+		writeAnnotationMarker(annot, annotBuffer)
+		if err := renderer.Render(annotBuffer, annot); err != nil {
+			return nil, err
+		}
+		rendered[i] = annotBuffer.Bytes()
+	}
+
+	region := new(bytes.Buffer)
+	switch groupBy {
+	case LiteratureGroupByColor:
+		// one heading per meaning in the order of the color scheme, colors
+		// that are not in the scheme come last
+		meanings := []api.ColorMeaning{}
+		for _, annot := range annots {
+			meaning := scheme.Get(annot.AnnotationColor)
+			if !slices.ContainsFunc(meanings, func(m api.ColorMeaning) bool { return m.Meaning == meaning.Meaning }) {
+				meanings = append(meanings, meaning)
+			}
+		}
+		sort.SliceStable(meanings, func(i, j int) bool {
+			return colorSchemeIndex(scheme, meanings[i]) < colorSchemeIndex(scheme, meanings[j])
+		})
+
+		for _, meaning := range meanings {
+			headerWritten := false
+			for i, annot := range annots {
+				if rendered[i] == nil || scheme.Get(annot.AnnotationColor).Meaning != meaning.Meaning {
+					continue
+				}
+				if !headerWritten {
+					writeGroupMarker(meaning, region)
+					headerWritten = true
+				}
+				region.Write(rendered[i])
+			}
+		}
+	default:
+		for _, text := range rendered {
+			region.Write(text)
+		}
 	}
-	text := fmt.Sprintf("underline %s(p.%s[%d]):\n`%s`\n%s\n",
-		ColorToIcon[annot.AnnotationColor],
-		annot.AnnotationPageLabel,
-		annot.AnnotationPosition.PageIndex,
-		annot.AnnotationText,
-		comment)
 
-	b.WriteString(text)
-	return nil
+	if len(existing) == 0 {
+		existing = []byte("\n")
+	}
 
-	return nil
+	return replaceRegion(existing, autogenBeginMarker, autogenEndMarker, region.Bytes()), nil
+}
+
+// Returns the index of the first color with the same meaning in the scheme,
+// meanings that are not in the scheme are placed after all others.
+func colorSchemeIndex(scheme api.ColorScheme, meaning api.ColorMeaning) int {
+	for i, m := range scheme {
+		if m.Meaning == meaning.Meaning {
+			return i
+		}
+	}
+	return len(scheme)
 }
@@ -1,8 +1,11 @@
 package client
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/spf13/viper"
 
@@ -14,27 +17,120 @@ import (
 )
 
 type BufferClientConfig struct {
-	soaDir string
+	soaDir                     string
+	literatureFilenameTemplate string
+	literatureGroupBy          LiteratureGroupBy
+	colorScheme                api.ColorScheme
+	zoteroDataDir              string
+	frontMatter                FrontMatter
+	backups                    int // number of .bak files kept on save
+	conflictPolicy             ConflictPolicy
+	lockTimeout                time.Duration // wait for other soa processes, see Lock
+	noLock                     bool          // do not take the vault lock
+}
+
+// Decides how the annotations are laid out in literature notes.
+type LiteratureGroupBy string
+
+const (
+	LiteratureGroupByNone  LiteratureGroupBy = "none"  // ordered by position in the attachement
+	LiteratureGroupByColor LiteratureGroupBy = "color" // one heading per color meaning
+)
+
+var ErrUnknownLiteratureGroupBy = errors.New("unknown literature group by")
+
+func ParseLiteratureGroupBy(s string) (LiteratureGroupBy, error) {
+	switch groupBy := LiteratureGroupBy(s); groupBy {
+	case LiteratureGroupByNone, LiteratureGroupByColor:
+		return groupBy, nil
+	case "":
+		return LiteratureGroupByNone, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownLiteratureGroupBy, s)
+	}
 }
 
 type BufferClient struct {
-	cfg *BufferClientConfig
+	cfg       *BufferClientConfig
+	renderers AnnotationRenderers // loaded on first use
+	lock      *vaultLock          // nil if locking is disabled
 }
 
 func NewBufferClient(cfg *BufferClientConfig) (*BufferClient, error) {
 	if cfg == nil {
+		groupBy, err := ParseLiteratureGroupBy(viper.GetString(config.KeyLiteratureGroupBy))
+		if err != nil {
+			return nil, err
+		}
+		frontMatter, err := ParseFrontMatter(viper.GetString(config.KeyFrontMatter))
+		if err != nil {
+			return nil, err
+		}
+		conflictPolicy, err := ParseConflictPolicy(viper.GetString(config.KeyOnConflict))
+		if err != nil {
+			return nil, err
+		}
+		var colorScheme api.ColorScheme
+		if err := viper.UnmarshalKey(config.KeyColors, &colorScheme); err != nil {
+			return nil, err
+		}
 		cfg = &BufferClientConfig{
-			soaDir: viper.GetString("vault-dir"),
+			soaDir:                     viper.GetString("vault-dir"),
+			literatureFilenameTemplate: viper.GetString(config.KeyLiteratureFilenameTemplate),
+			literatureGroupBy:          groupBy,
+			colorScheme:                colorScheme,
+			zoteroDataDir:              viper.GetString(config.KeyZoteroDataDir),
+			frontMatter:                frontMatter,
+			backups:                    viper.GetInt(config.KeyBackups),
+			conflictPolicy:             conflictPolicy,
+			lockTimeout:                viper.GetDuration(config.KeyLockTimeout),
+			noLock:                     viper.GetBool(config.KeyNoLock),
 		}
 	}
-	return &BufferClient{
+	if cfg.zoteroDataDir == "" {
+		cfg.zoteroDataDir = filepath.Join("~", config.DefaultZoteroDataDir)
+	}
+	zoteroDataDir, err := util.ExpandHome(cfg.zoteroDataDir)
+	if err != nil {
+		return nil, err
+	}
+	cfg.zoteroDataDir = zoteroDataDir
+	if len(cfg.colorScheme) == 0 {
+		cfg.colorScheme = api.DefaultColorScheme
+	}
+	if cfg.conflictPolicy == "" {
+		cfg.conflictPolicy = ConflictPolicyFail
+	}
+	if cfg.frontMatter == "" {
+		cfg.frontMatter = FrontMatterSoa
+	}
+	if cfg.literatureFilenameTemplate == "" {
+		cfg.literatureFilenameTemplate = config.DefaultLiteratureFilenameTemplate
+	}
+	if cfg.lockTimeout <= 0 {
+		cfg.lockTimeout = config.DefaultLockTimeout
+	}
+
+	c := &BufferClient{
 		cfg: cfg,
-	}, nil
+	}
+	if !cfg.noLock {
+		c.lock = &vaultLock{
+			path:    filepath.Join(cfg.soaDir, config.DefaultLockFile),
+			timeout: cfg.lockTimeout,
+		}
+	}
+	return c, nil
 }
 
 func (c *BufferClient) NewQuestion(rawTitle string, fromFile string, override bool) (*Buffer, error) {
 	logger := log.GlobalLogger
 
+	if err := c.Lock(); err != nil {
+		return nil, err
+	}
+	defer c.Unlock()
+
 	filename := util.QuestionFilename(rawTitle, datetime.CurrentDate())
 	sanitizedName, err := util.SanitizeName(filename)
 	if err != nil {
@@ -87,19 +183,289 @@ func (c *BufferClient) NewQuestion(rawTitle string, fromFile string, override bo
 		return nil, err
 	}
 
-	c.SaveBuffer(buff)
 	return buff, nil
 }
 
-func (c *BufferClient) NewLiterature(zoteroEntry *api.ZoteroCitationEntry, attachment *api.ZoteroAttachementItem, override bool) (*Buffer, error) {
+// Creates or updates the literature note of the zotero entry. Annotations of
+// all given attachements are merged into the note, if there are no
+// attachements only the metadata is written.
+func (c *BufferClient) NewLiterature(zoteroEntry *api.ZoteroCitationEntry, attachements []api.ZoteroAttachementItem, override bool) (*Buffer, error) {
+	if err := c.Lock(); err != nil {
+		return nil, err
+	}
+	defer c.Unlock()
+
+	pdfPath := ""
+	attachementPaths := []string{}
+	for _, attachement := range attachements {
+		attachementPaths = append(attachementPaths, attachement.Path)
+		if pdfPath == "" && isPDF(attachement.Path) {
+			pdfPath = attachement.Path
+		}
+	}
+	if pdfPath == "" && len(attachementPaths) != 0 {
+		pdfPath = attachementPaths[0]
+	}
+
+	// prefer the existing note so renamed notes are still updated
+	sanitizedPath, err := c.FindLiterature(zoteroEntry.CitationKey, pdfPath)
+	if err != nil {
+		return nil, err
+	}
+	if sanitizedPath == "" {
+		pdfName := ""
+		if pdfPath != "" {
+			pdfName = filepath.Base(pdfPath)
+		}
+		filename, err := util.LiteratureFilename(c.cfg.literatureFilenameTemplate, util.LiteratureFilenameData{
+			CitationKey: zoteroEntry.CitationKey,
+			Title:       zoteroEntry.Item.Title,
+			ShortTitle:  zoteroEntry.Item.ShortTitle,
+			Year:        util.ExtractYear(zoteroEntry.Item.Date),
+			PDF:         pdfName,
+			Date:        datetime.CurrentDate().String(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		sanitizedName, err := util.SanitizeName(filename)
+		if err != nil {
+			return nil, err
+		}
+		sanitizedPath = filepath.Join(c.cfg.soaDir, config.DefaultLiteraturesFolder, sanitizedName)
+	}
+
+	if !override && util.FileExists(sanitizedPath) {
+		return nil, os.ErrExist
+	}
+
+	buff, err := c.NewBufferFromFile(sanitizedPath, true)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create literature: %w", err)
+	}
+
+	literatureHeader, err := GetHeader[api.LiteratureHeader](buff) // cast it to literature
+	if err != nil {
+		return nil, fmt.Errorf("cannot read literature header: %w", err)
+	}
+
+	// set the header
+	if literatureHeader.Created.IsZero() {
+		literatureHeader.Created = datetime.CurrentDate()
+	}
+	generateLiteratureHeader(&literatureHeader, zoteroEntry)
+	if len(attachements) != 0 || literatureHeader.Attachements == nil { // metadata only syncs keep the attachements
+		literatureHeader.PDF = pdfPath
+		literatureHeader.Attachements = attachementPaths
+	}
+
+	// set the content, only the autogenerated region is rewritten. Metadata
+	// only notes keep their existing annotations.
+	if len(attachements) != 0 || buff.Content.Len() == 0 {
+		renderers, err := c.annotationRenderers()
+		if err != nil {
+			return nil, err
+		}
+		content, err := generateLiteratureContent(buff.Content, attachements, renderers, c.cfg.colorScheme, c.cfg.literatureGroupBy)
+		if err != nil {
+			return nil, err
+		}
+		buff.Content = content // set content buffer
+	}
+
+	// set origin
+	buff.Origin = sanitizedPath
+
+	if err := SetHeader(buff, literatureHeader); err != nil {
+		return nil, fmt.Errorf("cannot write literature header: %w", err)
+	}
+
+	if err := c.SaveBuffer(buff); err != nil {
+		return nil, fmt.Errorf("cannot write literature: %w", err)
+	}
+
+	return buff, nil
+}
+
+// Fetches the attachements of the zotero entry and creates or updates its
+// literature note, the attachements are selected according to the policy.
+func (c *BufferClient) SyncLiterature(zclient *ZoteroClient, zoteroEntry *api.ZoteroCitationEntry, policy AttachementPolicy, override bool) (*Buffer, error) {
+	var attachements []api.ZoteroAttachementItem
+	if policy != AttachementPolicyNone {
+		all, err := zclient.GetAttachements(zoteroEntry.CitationKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot retrieve attachements: %w", err)
+		}
+		attachements = SelectAttachements(policy, all)
+	}
+
+	return c.NewLiterature(zoteroEntry, attachements, override)
+}
+
+// Returns the annotation renderers, user templates under the vault override the
+// default ones.
+func (c *BufferClient) annotationRenderers() (AnnotationRenderers, error) {
+	if c.renderers != nil {
+		return c.renderers, nil
+	}
+
+	renderers, err := LoadAnnotationRenderers(filepath.Join(c.cfg.soaDir, config.DefaultTemplatesFolder), c.cfg.colorScheme)
+	if err != nil {
+		return nil, err
+	}
+
+	// image and ink annotations are exported from the zotero cache
+	for _, annotType := range []api.AnnotationType{api.Image, api.Ink} {
+		renderer, ok := renderers[annotType].(*TemplateRenderer)
+		if !ok {
+			continue
+		}
+		renderers[annotType] = NewImageRenderer(
+			renderer,
+			filepath.Join(c.cfg.zoteroDataDir, "cache", "library"),
+			filepath.Join(c.cfg.soaDir, config.DefaultAttachementsFolder),
+			filepath.Join(c.cfg.soaDir, config.DefaultLiteraturesFolder),
+		)
+	}
+	c.renderers = renderers
+	return renderers, nil
+}
+
+// Returns the path of the literature note with the given citation key, or
+// empty string if there is none. Notes written before citation keys were
+// stored in the header are matched by their pdf path. Notes that cannot be
+// read are skipped.
+func (c *BufferClient) FindLiterature(citationKey string, pdfPath string) (string, error) {
+	logger := log.GlobalLogger
+	if citationKey == "" {
+		return "", nil
+	}
+
+	dir := filepath.Join(c.cfg.soaDir, config.DefaultLiteraturesFolder)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	legacyMatch := ""
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".md" {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		buff, err := c.NewBufferFromFile(path, false)
+		if err != nil {
+			logger.Warnf("skipping %s while looking for %s: %v.\n", path, citationKey, err)
+			continue
+		}
+
+		key, _ := buff.Header["citation_key"].(string)
+		if key == citationKey {
+			return path, nil
+		}
+
+		pdf, _ := buff.Header["pdf"].(string)
+		if key == "" && pdfPath != "" && pdf == pdfPath && legacyMatch == "" {
+			legacyMatch = path
+		}
+	}
+
+	return legacyMatch, nil
+}
+
+func (c *BufferClient) NewMeeting(rawTitle string, project string, attendees []string, start datetime.DateTime, duration string, override bool) (*Buffer, error) {
+	logger := log.GlobalLogger
+
+	if err := c.Lock(); err != nil {
+		return nil, err
+	}
+	defer c.Unlock()
+
+	filename := util.MeetingFilename(rawTitle, datetime.Date{Time: start.Time})
+	sanitizedName, err := util.SanitizeName(filename)
+	if err != nil {
+		return nil, err
+	}
+	sanitizedPath := filepath.Join(c.cfg.soaDir, config.DefaultMeetingsFolder, sanitizedName)
+
+	if !override && util.FileExists(sanitizedPath) {
+		return nil, os.ErrExist
+	}
+
+	buff, err := c.NewBufferFromFile(sanitizedPath, true)
+	if err != nil {
+		logger.Errorf("cannot create meeting: %v.\n", err)
+		return nil, err
+	}
+
+	meetingHeader, err := GetHeader[api.MeetingHeader](buff) // cast it to meeting
+	if err != nil {
+		logger.Errorf("cannot read meeting header: %v.\n", err)
+		return nil, err
+	}
+
+	// set header
+	meetingHeader.Title = rawTitle
+	if meetingHeader.Created.IsZero() { // update creation time if it is null
+		meetingHeader.Created = datetime.CurrentDate()
+	}
+	if meetingHeader.Project == "" {
+		meetingHeader.Project = project
+	}
+	if len(meetingHeader.Attendees) == 0 {
+		meetingHeader.Attendees = attendees
+	}
+	if meetingHeader.Start.IsZero() {
+		meetingHeader.Start = start
+	}
+	if meetingHeader.Duration == "" {
+		meetingHeader.Duration = duration
+	}
+	if meetingHeader.Tags == nil {
+		meetingHeader.Tags = []string{}
+	}
+
+	// set content
+	content, err := generateMeetingContent(&meetingHeader)
+	if err != nil {
+		return nil, err
+	}
+	buff.Content = content // set content buffer
+
+	// set origin
+	buff.Origin = sanitizedPath
+
+	if err := SetHeader(buff, meetingHeader); err != nil {
+		logger.Errorf("cannot write meeting header: %v.\n", err)
+		return nil, err
+	}
+
+	if err := c.SaveBuffer(buff); err != nil {
+		logger.Errorf("cannot write meeting: %v.\n", err)
+		return nil, err
+	}
+
+	return buff, nil
+}
+
+func (c *BufferClient) NewPermanent(rawTitle string, tags []string, sources []string, links []string, override bool) (*Buffer, error) {
 	logger := log.GlobalLogger
 
-	filename := util.LiteratureFilename(attachment.Path, datetime.CurrentDate())
+	if err := c.Lock(); err != nil {
+		return nil, err
+	}
+	defer c.Unlock()
+
+	id := util.NewZettelID(time.Now())
+	filename := util.PermanentFilename(id, rawTitle)
 	sanitizedName, err := util.SanitizeName(filename)
 	if err != nil {
 		return nil, err
 	}
-	sanitizedPath := filepath.Join(c.cfg.soaDir, config.DefaultLiteraturesFolder, sanitizedName)
+	sanitizedPath := filepath.Join(c.cfg.soaDir, config.DefaultPermanentFolder, sanitizedName)
 
 	if !override && util.FileExists(sanitizedPath) {
 		return nil, os.ErrExist
@@ -107,25 +473,30 @@ func (c *BufferClient) NewLiterature(zoteroEntry *api.ZoteroCitationEntry, attac
 
 	buff, err := c.NewBufferFromFile(sanitizedPath, true)
 	if err != nil {
-		logger.Fatalf("cannot create literature: %v.\n", err)
+		logger.Errorf("cannot create permanent: %v.\n", err)
 		return nil, err
 	}
 
-	literatureHeader, err := GetHeader[api.LiteratureHeader](buff) // cast it to question
+	permanentHeader, err := GetHeader[api.PermanentHeader](buff) // cast it to permanent
 	if err != nil {
-		logger.Fatalf("cannot read literature header: %v.\n", err)
+		logger.Errorf("cannot read permanent header: %v.\n", err)
 		return nil, err
 	}
 
-	// set the header
-	if literatureHeader.Created.IsZero() {
-		literatureHeader.Created = datetime.CurrentDate()
+	// set header
+	permanentHeader.Title = rawTitle
+	if permanentHeader.Created.IsZero() { // update creation time if it is null
+		permanentHeader.Created = datetime.CurrentDate()
 	}
-	literatureHeader.PDF = attachment.Path
-	literatureHeader.Tags = []string{} // for now empty
+	if permanentHeader.ID == "" { // never change the id of an existing note
+		permanentHeader.ID = id
+	}
+	permanentHeader.Tags = nonNil(permanentHeader.Tags, tags)
+	permanentHeader.Sources = nonNil(permanentHeader.Sources, sources)
+	permanentHeader.Links = nonNil(permanentHeader.Links, links)
 
-	// set the content
-	content, err := generateLiteratureContent(attachment)
+	// set content
+	content, err := generatePermanentContent(&permanentHeader)
 	if err != nil {
 		return nil, err
 	}
@@ -134,15 +505,27 @@ func (c *BufferClient) NewLiterature(zoteroEntry *api.ZoteroCitationEntry, attac
 	// set origin
 	buff.Origin = sanitizedPath
 
-	if err := SetHeader(buff, literatureHeader); err != nil {
-		logger.Fatalf("cannot write literature header: %v.\n", err)
+	if err := SetHeader(buff, permanentHeader); err != nil {
+		logger.Errorf("cannot write permanent header: %v.\n", err)
 		return nil, err
 	}
 
 	if err := c.SaveBuffer(buff); err != nil {
-		logger.Fatalf("cannot write literature header: %v.\n", err)
+		logger.Errorf("cannot write permanent: %v.\n", err)
 		return nil, err
 	}
 
 	return buff, nil
 }
+
+// Returns the existing list if it is not empty, otherwise the given list. The
+// result is never nil so empty lists are written as [] to the header.
+func nonNil(existing []string, given []string) []string {
+	if len(existing) != 0 {
+		return existing
+	}
+	if given == nil {
+		return []string{}
+	}
+	return given
+}
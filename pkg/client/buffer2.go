@@ -4,19 +4,22 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"reflect"
+	"strings"
 
 	"github.com/iancoleman/strcase"
 	"gopkg.in/yaml.v3"
 
 	"github.com/ubombar/soa/api"
 	"github.com/ubombar/soa/internal/util"
+	"github.com/ubombar/soa/pkg/diff"
 )
 
 const (
-	headerSeperator = "--"
+	headerSeperator = "--"      // delimiter of the soa front matter
 	UnknownKind     = "unknown" // special key that persists
 )
 
@@ -26,163 +29,407 @@ type Buffer struct {
 	Content *bytes.Buffer  // Raw contents of the buffer
 	Header  map[string]any // Raw header
 	Origin  string
+
+	document    *yaml.Node  // header as read, keeps the key order, comments and nested values
+	source      FrontMatter // dialect of the header as read, empty if there was none
+	frontMatter FrontMatter // dialect the header is written in
+	loaded      *fileState  // file as read, nil for in memory buffers
+
+	newline      string         // line ending of the note as read, \n or \r\n, content and header hold \n in memory
+	mixed        bool           // some lines end differently than newline, their endings are kept on write
+	raw          []byte         // note as read, written back as is while the buffer is unchanged
+	rawContent   []byte         // content as read
+	headerAsRead map[string]any // copy of the header as read
+	unterminated bool           // the closing header delimiter was the end of the file
+	closing      string         // closing header delimiter as read, yaml headers can also end with ...
+	rawHeader    []byte         // header as read, unchanged entries are written from it
+	kindInjected bool           // kind was missing and set to UnknownKind on read, it is not written back unless changed
 }
 
 func (c *BufferClient) NewBuffer() *Buffer {
 	return &Buffer{
-		Content: new(bytes.Buffer),
-		Header:  map[string]any{"kind": UnknownKind},
-		Origin:  "", // empty means in memory
+		Content:     new(bytes.Buffer),
+		Header:      map[string]any{"kind": UnknownKind},
+		Origin:      "", // empty means in memory
+		frontMatter: c.cfg.frontMatter,
+		newline:     "\n",
 	}
 }
 
-func (c *BufferClient) NewBufferFromFile(filename string, create bool) (*Buffer, error) {
-	var f *os.File
-	var err error
+// Returns the dialect of the header as it was read, empty if the buffer had
+// no header.
+func (b *Buffer) SourceFrontMatter() FrontMatter {
+	return b.source
+}
 
-	if util.FileExists(filename) {
-		f, err = os.Open(filename)
-	} else if create {
-		f, err = os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0644)
-	} else {
-		return nil, os.ErrNotExist
+// Sets the dialect the header is written in. Headers keep the dialect they
+// were read in, new ones use the configured dialect.
+func (b *Buffer) SetFrontMatter(frontMatter FrontMatter) {
+	b.frontMatter = frontMatter
+}
+
+func (c *BufferClient) NewBufferFromFile(filename string, create bool) (*Buffer, error) {
+	if !util.FileExists(filename) {
+		if !create {
+			return nil, os.ErrNotExist
+		}
+		f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
 	}
 
+	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
-
 	defer f.Close()
 
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
 	b := c.NewBuffer()
 
-	if err := b.read(f); err != nil {
+	if err := b.read(bytes.NewReader(raw)); err != nil {
 		return nil, err
 	}
 	b.Origin = filename // set the origin
+	b.loaded = newFileState(raw, info)
 	return b, nil
 }
 
+// Writes the buffer to its origin. If the file changed on disk since the
+// buffer was read the configured conflict policy applies, by default the
+// save fails with a *ConflictError.
 func (c *BufferClient) SaveBuffer(b *Buffer) error {
 	if b.Origin == "" {
 		return ErrCannotSaveInMemoryBuffer
 	}
+	if err := c.Lock(); err != nil {
+		return err
+	}
+	defer c.Unlock()
 
-	f, err := os.Create(b.Origin)
+	// marshal first so that errors leave the note untouched
+	data, err := b.Bytes()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	if data, err = c.resolveConflict(b, data); err != nil {
+		return err
+	}
+
+	if err := util.WriteFileAtomic(b.Origin, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}, c.cfg.backups); err != nil {
+		return err
+	}
 
-	return b.write(f)
+	// later saves are checked against what was written
+	info, err := os.Stat(b.Origin)
+	if err != nil {
+		return err
+	}
+	b.loaded = newFileState(data, info)
+	return nil
+}
+
+// Returns the buffer as it would be saved.
+func (b *Buffer) Bytes() ([]byte, error) {
+	var out bytes.Buffer
+	if err := b.write(&out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
 }
 
 func (b *Buffer) read(f io.Reader) error {
 	var headerBuffer bytes.Buffer
 	var contentBuffer bytes.Buffer
 
-	scanner := bufio.NewScanner(f)
-	noHeader := false
+	var raw bytes.Buffer
+	reader := bufio.NewReader(f)
+	var source FrontMatter
+	newline := ""
+	mixed := false
 	inContent := false
 	lineNum := 1
 
-	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
 			return err
 		}
+		if line == "" {
+			break // end of file
+		}
 
-		text := scanner.Text()
+		raw.WriteString(line)
 
-		// does not contain header
-		if lineNum == 1 && text != headerSeperator {
-			noHeader = true
-			inContent = true
-		} else if lineNum == 1 && text == headerSeperator {
-			noHeader = false
-			inContent = false
+		// the first line ending decides the newline style of the note, lines
+		// ending otherwise are remembered
+		text, ending := splitLineEnding(line)
+		if newline == "" {
+			newline = ending
+		} else if ending != "" && ending != newline {
+			mixed = true
+		}
+		if ending != "" {
+			ending = "\n" // kept as \n in memory, written back as read
+		}
+
+		if lineNum == 1 {
 			lineNum++
-			continue
-		} else if lineNum != 1 && text == headerSeperator && !noHeader {
+			if frontMatter, ok := frontMatterOf(text); ok {
+				source = frontMatter
+				b.unterminated = ending == ""
+				continue
+			}
+			inContent = true // does not contain header
+		} else if !inContent && source.closes(text) {
 			inContent = true
 			lineNum++
+			b.closing = text
+			b.unterminated = ending == ""
 			continue
 		}
 
 		if inContent {
-			if _, err := contentBuffer.WriteString(text); err != nil {
-				return err
-			}
-			if err := contentBuffer.WriteByte('\n'); err != nil {
-				return err
-			}
+			contentBuffer.WriteString(text + ending)
+		} else if ending != "" {
+			headerBuffer.WriteString(text + ending)
 		} else {
-			if _, err := headerBuffer.WriteString(text); err != nil {
-				return err
-			}
-			if err := headerBuffer.WriteByte('\n'); err != nil {
-				return err
-			}
+			headerBuffer.WriteString(text + "\n") // header that is never closed
 		}
-
 		lineNum++
 	}
 
 	// parse header
-	result := make(map[string]any)
-	if err := yaml.Unmarshal(headerBuffer.Bytes(), &result); err != nil {
+	document := new(yaml.Node)
+	if source == FrontMatterTOML {
+		var err error
+		if document, err = tomlDocument(headerBuffer.Bytes()); err != nil {
+			return err
+		}
+	} else if err := yaml.Unmarshal(headerBuffer.Bytes(), document); err != nil {
 		return err
 	}
+	result := make(map[string]any)
+	if len(document.Content) != 0 && document.Content[0].Tag != "!!null" { // empty header
+		if err := document.Decode(&result); err != nil {
+			return err
+		}
+	}
 
 	for k, v := range result {
 		switch v.(type) {
-		case map[string]interface{}, map[interface{}]interface{}:
-			delete(result, k) // delete all map values
+		case map[interface{}]interface{}:
+			delete(result, k) // maps with non string keys are only kept in the document
 		}
 	}
 
 	// set itself
 	b.Content = &contentBuffer
 	b.Header = result
+	b.document = document
+	b.rawHeader = headerBuffer.Bytes()
+	b.source = source
+	if source != "" {
+		b.frontMatter = source // existing headers keep their dialect
+	}
+	if newline != "" {
+		b.newline = newline
+	}
+	b.mixed = mixed
+	b.raw = raw.Bytes()
+	b.rawContent = bytes.Clone(contentBuffer.Bytes())
 
 	// add special key "kind"
 	if _, ok := b.Header["kind"]; !ok {
 		b.Header["kind"] = UnknownKind
+		b.kindInjected = true
 	}
+	b.headerAsRead = copyValue(b.Header).(map[string]any)
 
 	return nil
 }
 
 func (b *Buffer) write(f io.Writer) error {
-	writer := bufio.NewWriter(f)
-
-	// Write first header separator
-	if _, err := writer.WriteString(headerSeperator + "\n"); err != nil {
+	if b.unchanged() {
+		_, err := f.Write(b.raw)
 		return err
 	}
 
-	// Marshal header to YAML and write it
-	headerBytes, err := yaml.Marshal(b.Header)
-	if err != nil {
-		return err
+	var out bytes.Buffer
+	writer := bufio.NewWriter(&out)
+
+	newline := b.newline
+	if newline == "" {
+		newline = "\n"
 	}
-	if _, err := writer.Write(headerBytes); err != nil {
+
+	// Marshal header, only the changed keys are rewritten
+	headerBytes, changed, err := b.marshalHeader()
+	if err != nil {
 		return err
 	}
 
-	// Write second header separator
-	if _, err := writer.WriteString(headerSeperator + "\n"); err != nil {
-		return err
+	// notes without a header keep having none unless a key is set
+	if b.source != "" || changed {
+		delimiter := b.frontMatter.delimiter()
+		closing := delimiter
+		if b.frontMatter == b.source && b.closing != "" {
+			closing = b.closing
+		}
+		if !b.unterminated || b.Content.Len() != 0 { // a note that ends with the header keeps doing so
+			closing += "\n"
+		}
+
+		// Write first header separator
+		if _, err := writer.WriteString(delimiter + "\n"); err != nil {
+			return err
+		}
+		if _, err := writer.Write(headerBytes); err != nil {
+			return err
+		}
+		// Write second header separator
+		if _, err := writer.WriteString(closing); err != nil {
+			return err
+		}
 	}
 
 	// Write content
 	if _, err := writer.Write(b.Content.Bytes()); err != nil {
 		return err
 	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	// Restore the line endings
+	data := out.Bytes()
+	if b.mixed {
+		data = restoreNewlines(b.raw, data, newline)
+	} else {
+		data = withNewline(data, newline)
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// Reports whether the buffer is the same as read, in memory buffers never
+// are.
+func (b *Buffer) unchanged() bool {
+	return b.raw != nil && b.frontMatter == b.source && bytes.Equal(b.Content.Bytes(), b.rawContent) && !b.headerChanged()
+}
+
+// Returns the header in the dialect of the buffer and whether it differs
+// from the header as read.
+func (b *Buffer) marshalHeader() ([]byte, bool, error) {
+	changed := b.headerChanged()
+	if !changed && b.frontMatter == b.source {
+		return b.rawHeader, false, nil // written byte for byte
+	}
+
+	var headerBytes []byte
+	var err error
+	switch {
+	case b.frontMatter == FrontMatterTOML:
+		headerBytes, err = tomlHeader(b.updateDocument())
+	case b.source != FrontMatterTOML:
+		headerBytes, err = b.spliceHeader()
+	default:
+		headerBytes, err = yaml.Marshal(b.updateDocument())
+	}
+	return headerBytes, changed, err
+}
+
+// Splits the line read by bufio.Reader.ReadString into its text and line
+// ending, the ending is empty for the last line of a file without a trailing
+// newline.
+func splitLineEnding(line string) (string, string) {
+	if text, ok := strings.CutSuffix(line, "\r\n"); ok {
+		return text, "\r\n"
+	}
+	if text, ok := strings.CutSuffix(line, "\n"); ok {
+		return text, "\n"
+	}
+	return line, ""
+}
 
-	return writer.Flush()
+// Converts the \n line endings of data to the line endings of the original
+// for the lines that are in both, the other lines end with newline.
+func restoreNewlines(original []byte, data []byte, newline string) []byte {
+	originalLines := diff.SplitLines(string(original))
+	normalized := make([]string, len(originalLines))
+	for i, line := range originalLines {
+		text, ending := splitLineEnding(line)
+		if ending != "" {
+			ending = "\n"
+		}
+		normalized[i] = text + ending
+	}
+
+	var out bytes.Buffer
+	for _, op := range diff.Lines(normalized, diff.SplitLines(string(data))) {
+		switch op.Kind {
+		case diff.Equal:
+			out.WriteString(originalLines[op.OldLine])
+		case diff.Insert:
+			out.Write(withNewline([]byte(op.Text), newline))
+		}
+	}
+	return out.Bytes()
+}
+
+// Converts the \n line endings to the given newline.
+func withNewline(data []byte, newline string) []byte {
+	if newline == "\n" {
+		return data
+	}
+	return bytes.ReplaceAll(data, []byte("\n"), []byte(newline))
+}
+
+// Header field whose value cannot be read into the typed header.
+type FieldError struct {
+	Field    string // header key
+	Expected string // type of the struct field
+	Actual   any    // value in the header
+}
+
+func (e FieldError) Error() string {
+	if s, ok := e.Actual.(string); ok {
+		return fmt.Sprintf("%s: expected %s, got %q", e.Field, e.Expected, s)
+	}
+	return fmt.Sprintf("%s: expected %s, got %v (%T)", e.Field, e.Expected, e.Actual, e.Actual)
+}
+
+// Returned in strict mode when some fields of the header are invalid.
+type HeaderError struct {
+	Kind   string
+	Fields []FieldError
+}
+
+func (e *HeaderError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		fields = append(fields, field.Error())
+	}
+	return fmt.Sprintf("invalid %s header: %s", e.Kind, strings.Join(fields, "; "))
 }
 
-func (b *Buffer) readHeader(obj any, preferStruct bool) error {
+// Populates the struct from the header. Values are decoded into the fields
+// through yaml, fields that cannot be decoded are skipped. In strict mode the
+// failing fields are returned as a *HeaderError instead.
+func (b *Buffer) readHeader(obj any, preferStruct bool, strict bool) error {
 	v := reflect.ValueOf(obj)
 	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
 		return &reflect.ValueError{Method: "PopulateStructFromMap", Kind: v.Kind()}
@@ -190,6 +437,7 @@ func (b *Buffer) readHeader(obj any, preferStruct bool) error {
 
 	v = v.Elem()
 	t := v.Type()
+	invalid := []FieldError{}
 
 	for i := 0; i < v.NumField(); i++ {
 		field := v.Field(i)
@@ -205,36 +453,82 @@ func (b *Buffer) readHeader(obj any, preferStruct bool) error {
 			tag = strcase.ToSnake(structField.Name) // fallback to field name snake cased
 		}
 
-		if val, ok := b.Header[tag]; ok && !preferStruct {
-			valValue := reflect.ValueOf(val)
-
-			// If assignable, set it
-			if valValue.Type().AssignableTo(field.Type()) {
-				field.Set(valValue)
-			} else if valValue.Type().ConvertibleTo(field.Type()) {
-				field.Set(valValue.Convert(field.Type()))
-			} else {
-				in, ok := val.([]interface{})
-				out := make([]string, 0, len(in))
-				if !ok {
-					continue // skip non-flat header
-				}
-				for _, v := range in {
-					str, ok := v.(string)
-					if !ok {
-						continue
-					}
-					out = append(out, str)
-				}
-				field.Set(reflect.ValueOf(out))
-			}
+		val, ok := b.Header[tag]
+		if !ok || preferStruct {
+			continue
+		}
+
+		if val == nil {
+			continue // null leaves the zero value
+		}
+
+		// values set by SetHeader already have the field type
+		if valValue := reflect.ValueOf(val); valValue.Type().AssignableTo(field.Type()) {
+			field.Set(valValue)
+			continue
+		}
+
+		node, err := b.headerNode(tag, val)
+		var out reflect.Value
+		if err == nil {
+			out, err = decodeNode(node, field.Type())
+		}
+
+		// yaml decodes numbers and booleans into strings, strict mode only
+		// takes strings
+		if err == nil && strict && !stringScalars(node, field.Type()) {
+			err = errNotString
+		}
 
+		var typeErr *yaml.TypeError
+		switch {
+		case err == nil:
+			field.Set(out)
+		case strict:
+			invalid = append(invalid, FieldError{Field: tag, Expected: field.Type().String(), Actual: val})
+		case errors.As(err, &typeErr):
+			field.Set(out) // keep the items that could be decoded
 		}
 	}
 
+	if len(invalid) != 0 {
+		kind, _ := b.Header["kind"].(string)
+		return &HeaderError{Kind: kind, Fields: invalid}
+	}
 	return nil
 }
 
+var errNotString = errors.New("not a string")
+
+// Reports whether the scalars decoded into string and string list fields are
+// tagged as strings, so that tags: [1, 2] is not taken as a string list.
+// Types with their own unmarshaler are not checked.
+func stringScalars(node *yaml.Node, t reflect.Type) bool {
+	if reflect.PointerTo(t).Implements(reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()) {
+		return true
+	}
+	switch {
+	case t.Kind() == reflect.String:
+		return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!str"
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String:
+		for _, item := range node.Content {
+			if !stringScalars(item, t.Elem()) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Decodes the node into a new value of the given type, so types implementing
+// yaml.Unmarshaler such as datetime.Date are parsed by themselves. On
+// *yaml.TypeError the value holds the parts that could be decoded.
+func decodeNode(node *yaml.Node, t reflect.Type) (reflect.Value, error) {
+	out := reflect.New(t)
+	err := node.Decode(out.Interface())
+	return out.Elem(), err
+}
+
 func (b *Buffer) writeHeader(obj any, preferMap bool, kind string) error {
 	v := reflect.ValueOf(obj)
 	if v.Kind() == reflect.Ptr {
@@ -263,7 +557,15 @@ func (b *Buffer) writeHeader(obj any, preferMap bool, kind string) error {
 			tag = strcase.ToSnake(structField.Name)
 		}
 
-		if _, ok := b.Header[tag]; !ok || (ok && !preferMap) { // not on map
+		_, ok := b.Header[tag]
+		_, wasRead := b.headerAsRead[tag]
+		// zero values of keys the header did not have would only add lines
+		// to its diff, notes without a header get every key
+		if !ok && !wasRead && b.source != "" && field.IsZero() {
+			continue
+		}
+
+		if !ok || (ok && !preferMap) { // not on map
 			b.Header[tag] = field.Interface()
 		}
 	}
@@ -275,12 +577,39 @@ func (b *Buffer) writeHeader(obj any, preferMap bool, kind string) error {
 
 func GetHeader[T api.Kinder](b *Buffer) (T, error) {
 	var header T
-	if err := b.readHeader(&header, false); err != nil {
+	if err := b.readHeader(&header, false, false); err != nil {
 		return header, err
 	}
 	return header, nil
 }
 
+// Same as GetHeader but fails with a *HeaderError listing every field whose
+// value does not fit its type, instead of skipping them.
+func GetHeaderStrict[T api.Kinder](b *Buffer) (T, error) {
+	var header T
+	if err := b.readHeader(&header, false, true); err != nil {
+		return header, err
+	}
+	return header, nil
+}
+
+// Validates the header against the typed header of its kind, see
+// GetHeaderStrict. Kinds without a typed header are not checked.
+func ValidateHeader(b *Buffer) error {
+	var err error
+	switch b.Header["kind"] {
+	case api.QuestionHeader{}.Kind():
+		_, err = GetHeaderStrict[api.QuestionHeader](b)
+	case api.LiteratureHeader{}.Kind():
+		_, err = GetHeaderStrict[api.LiteratureHeader](b)
+	case api.MeetingHeader{}.Kind():
+		_, err = GetHeaderStrict[api.MeetingHeader](b)
+	case api.PermanentHeader{}.Kind():
+		_, err = GetHeaderStrict[api.PermanentHeader](b)
+	}
+	return err
+}
+
 func SetHeader[T api.Kinder](b *Buffer, header T) error {
 	if err := b.writeHeader(&header, false, header.Kind()); err != nil {
 		return err
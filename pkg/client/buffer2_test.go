@@ -0,0 +1,520 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ubombar/soa/api"
+	"github.com/ubombar/soa/pkg/diff"
+)
+
+// Header entries in the yaml dialects, written the way people write them by
+// hand.
+var yamlEntries = []string{
+	"kind: question",
+	"kind: permanent",
+	"title: Plain title",
+	"title: 'Single quoted'",
+	"title: \"Double quoted\"",
+	"question:   spaced out   # line comment",
+	"created: 2024-01-01",
+	"created: \"2024-01-01\"",
+	"start: 2024-01-01 10:00:00",
+	"tags: [net, routing]",
+	"tags:\n  - net\n  - \"routing\"",
+	"tags:\n    - indented four",
+	"tags: []",
+	"sources:\n- no indent",
+	"from: \"[[Q 2024-01-01 Why]]\"",
+	"id: 20240101120000-abcd",
+	"year: 2024",
+	"ratio: 1.50",
+	"done: yes",
+	"empty:",
+	"nothing: ~",
+	"nested:\n  a: 1\n  b: [1, 2]\n  c:\n    d: deep",
+	"flow: {a: 1, b: two}",
+	"\"quoted key\": value",
+	"abstract: |\n  first line\n\n  second line",
+	"folded: >-\n  folded\n  text",
+	"long: " + strings.Repeat("long ", 20000),
+	"# comment line",
+	"#comment without space",
+	"",
+}
+
+// Header entries in the toml dialect.
+var tomlEntries = []string{
+	"kind = \"literature\"",
+	"title = 'Literal'",
+	"created = 2024-01-01",
+	"tags = [\"a\", \"b\"]",
+	"year = 2024",
+	"# comment line",
+	"",
+}
+
+var contentLines = []string{
+	"# Heading",
+	"",
+	"Some text with a [[Wiki link]] and a [link](other.md).",
+	"- item",
+	"<!-- soa:autogen:begin -->",
+	"<!-- soa:autogen:end -->",
+	"--",
+	"---",
+	"trailing spaces   ",
+	"\ttabbed",
+	strings.Repeat("x", 100000),
+}
+
+// Returns a note with a random header and content. The header keys are in
+// random order, some notes have no kind, no header, no final newline, \r\n or
+// mixed line endings.
+func randomNote(r *rand.Rand) string {
+	var lines []string
+
+	entries := yamlEntries
+	delimiter := []string{"--", "---", "+++", ""}[r.Intn(4)]
+	if delimiter == "+++" {
+		entries = tomlEntries
+	}
+	if delimiter != "" {
+		lines = append(lines, delimiter)
+		used := map[string]bool{}
+		for _, i := range r.Perm(len(entries))[:r.Intn(len(entries))] {
+			entry := entries[i]
+			key, _, _ := strings.Cut(entry, ":")
+			if delimiter == "+++" {
+				key, _, _ = strings.Cut(entry, "=")
+			}
+			if !strings.HasPrefix(entry, "#") && entry != "" {
+				if used[key] {
+					continue
+				}
+				used[key] = true
+			}
+			lines = append(lines, strings.Split(entry, "\n")...)
+		}
+		if delimiter == "---" && r.Intn(4) == 0 {
+			lines = append(lines, "...")
+		} else {
+			lines = append(lines, delimiter)
+		}
+	}
+	for i := r.Intn(6); i > 0; i-- {
+		lines = append(lines, contentLines[r.Intn(len(contentLines))])
+	}
+
+	var b strings.Builder
+	newline := []string{"\n", "\r\n"}[r.Intn(2)]
+	for _, line := range lines {
+		b.WriteString(line)
+		if r.Intn(10) == 0 {
+			b.WriteString("\r\n\n"[len(newline)-1:]) // the other line ending
+		} else {
+			b.WriteString(newline)
+		}
+	}
+	note := b.String()
+	if r.Intn(4) == 0 {
+		note = strings.TrimRight(note, "\r\n")
+	}
+	return note
+}
+
+func newTestClient(t testing.TB) (*BufferClient, string) {
+	dir := t.TempDir()
+	c, err := NewBufferClient(&BufferClientConfig{soaDir: dir, zoteroDataDir: dir, noLock: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, dir
+}
+
+// Loads the note from a file and saves it, returns the saved bytes. Notes
+// that cannot be read return false.
+func loadAndSave(t testing.TB, c *BufferClient, path string, note string, edit func(*Buffer)) ([]byte, bool) {
+	if err := os.WriteFile(path, []byte(note), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.NewBufferFromFile(path, false)
+	if err != nil {
+		return nil, false
+	}
+	if edit != nil {
+		edit(b)
+	}
+	if err := c.SaveBuffer(b); err != nil {
+		t.Fatalf("cannot save %q: %v", note, err)
+	}
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return saved, true
+}
+
+func TestRoundTripUnmodified(t *testing.T) {
+	c, dir := newTestClient(t)
+	path := filepath.Join(dir, "note.md")
+	r := rand.New(rand.NewSource(1))
+
+	read := 0
+	for i := 0; i < 1000; i++ {
+		note := randomNote(r)
+		saved, ok := loadAndSave(t, c, path, note, nil)
+		if !ok {
+			continue
+		}
+		read++
+		if string(saved) != note {
+			t.Fatalf("note changed on save\n%s", diff.Unified("read", "saved", note, string(saved)))
+		}
+	}
+	if read < 500 {
+		t.Fatalf("only %d generated notes could be read", read)
+	}
+}
+
+// Appending to the content leaves the rest of the note as it was, the header
+// is written from its lines as read and every line keeps its ending.
+func TestRoundTripContentEdit(t *testing.T) {
+	c, dir := newTestClient(t)
+	path := filepath.Join(dir, "note.md")
+	r := rand.New(rand.NewSource(4))
+
+	for i := 0; i < 1000; i++ {
+		note := randomNote(r)
+		if !strings.HasSuffix(note, "\n") {
+			continue
+		}
+		newline := "\n"
+		if first, _, _ := strings.Cut(note, "\n"); strings.HasSuffix(first, "\r") {
+			newline = "\r\n"
+		}
+
+		closed := true
+		saved, ok := loadAndSave(t, c, path, note, func(b *Buffer) {
+			closed = b.source == "" || b.closing != ""
+			b.Content.WriteString("added\n")
+		})
+		if !ok || !closed {
+			continue // headers that are never closed get closed
+		}
+		if want := note + "added" + newline; string(saved) != want {
+			t.Fatalf("note changed on save\n%s", diff.Unified("want", "saved", want, string(saved)))
+		}
+	}
+}
+
+func TestRoundTripModified(t *testing.T) {
+	c, dir := newTestClient(t)
+	path := filepath.Join(dir, "note.md")
+	r := rand.New(rand.NewSource(2))
+
+	for i := 0; i < 500; i++ {
+		note := randomNote(r)
+		saved, ok := loadAndSave(t, c, path, note, func(b *Buffer) {
+			b.Header["title"] = "Changed title"
+			b.Header["added"] = []string{"new"}
+			delete(b.Header, "year")
+		})
+		if !ok {
+			continue
+		}
+
+		// the changes read back and the other keys keep their values
+		before := c.NewBuffer()
+		if err := before.read(strings.NewReader(note)); err != nil {
+			t.Fatal(err)
+		}
+		after, err := c.NewBufferFromFile(path, false)
+		if err != nil {
+			t.Fatalf("cannot read saved note: %v\n%s", err, diff.Unified("read", "saved", note, string(saved)))
+		}
+		if after.Header["title"] != "Changed title" || fmt.Sprint(after.Header["added"]) != "[new]" {
+			t.Fatalf("changes are lost\n%s", diff.Unified("read", "saved", note, string(saved)))
+		}
+		if _, ok := after.Header["year"]; ok {
+			t.Fatalf("removed key is kept\n%s", diff.Unified("read", "saved", note, string(saved)))
+		}
+		for key, value := range before.Header {
+			if key == "title" || key == "year" || (key == "kind" && before.kindInjected) {
+				continue
+			}
+			if fmt.Sprint(after.Header[key]) != fmt.Sprint(value) {
+				t.Fatalf("%s changed from %v to %v\n%s", key, value, after.Header[key], diff.Unified("read", "saved", note, string(saved)))
+			}
+		}
+		if !bytes.Equal(after.Content.Bytes(), before.Content.Bytes()) {
+			t.Fatalf("content changed\n%s", diff.Unified("read", "saved", note, string(saved)))
+		}
+	}
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	r := rand.New(rand.NewSource(3))
+	for seeds := 0; seeds < 20; {
+		if note := randomNote(r); len(note) < 4096 { // long lines make the fuzzer crawl
+			f.Add(note)
+			seeds++
+		}
+	}
+	f.Fuzz(func(t *testing.T, note string) {
+		c, dir := newTestClient(t)
+		saved, ok := loadAndSave(t, c, filepath.Join(dir, "note.md"), note, nil)
+		if ok && string(saved) != note {
+			t.Fatalf("note changed on save\n%s", diff.Unified("read", "saved", note, string(saved)))
+		}
+	})
+}
+
+func TestMinimalDiff(t *testing.T) {
+	const note = "---\n" +
+		"# about the note\n" +
+		"title: 'Old title'   # shown in lists\n" +
+		"kind: permanent\n" +
+		"\n" +
+		"tags:\n" +
+		"  - net\n" +
+		"  - routing\n" +
+		"meta:\n" +
+		"  source: \"book\"\n" +
+		"year: 2024\n" +
+		"---\n" +
+		"# Old title\n"
+
+	tests := []struct {
+		name string
+		edit func(*Buffer)
+		want string
+	}{
+		{
+			name: "changed scalar keeps its comment",
+			edit: func(b *Buffer) { b.Header["title"] = "New title" },
+			want: strings.Replace(note, "title: 'Old title'   # shown in lists\n", "title: New title # shown in lists\n", 1),
+		},
+		{
+			name: "changed list keeps the indentation",
+			edit: func(b *Buffer) { b.Header["tags"] = []string{"net", "routing", "bgp"} },
+			want: strings.Replace(note, "  - routing\n", "  - routing\n  - bgp\n", 1),
+		},
+		{
+			name: "removed key",
+			edit: func(b *Buffer) { delete(b.Header, "year") },
+			want: strings.Replace(note, "year: 2024\n", "", 1),
+		},
+		{
+			name: "new keys are appended sorted",
+			edit: func(b *Buffer) { b.Header["links"] = []string{"a"}; b.Header["id"] = "x" },
+			want: strings.Replace(note, "year: 2024\n", "year: 2024\nid: x\nlinks:\n  - a\n", 1),
+		},
+		{
+			name: "typed header only rewrites the changed key",
+			edit: func(b *Buffer) {
+				h, err := GetHeader[api.PermanentHeader](b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				h.Title = "New title"
+				if err := SetHeader(b, h); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: strings.Replace(note, "title: 'Old title'   # shown in lists\n", "title: New title # shown in lists\n", 1),
+		},
+	}
+
+	c, dir := newTestClient(t)
+	path := filepath.Join(dir, "note.md")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			saved, ok := loadAndSave(t, c, path, note, test.edit)
+			if !ok {
+				t.Fatal("cannot read note")
+			}
+			if string(saved) != test.want {
+				t.Fatalf("unexpected save\n%s", diff.Unified("want", "saved", test.want, string(saved)))
+			}
+		})
+	}
+}
+
+func TestNoKindInjected(t *testing.T) {
+	c, dir := newTestClient(t)
+	path := filepath.Join(dir, "note.md")
+
+	for _, note := range []string{"no header\n", "--\ntitle: x\n--\nbody\n", "---\n---\n"} {
+		saved, ok := loadAndSave(t, c, path, note, func(b *Buffer) {
+			b.Content.WriteString("more\n")
+		})
+		if !ok {
+			t.Fatalf("cannot read %q", note)
+		}
+		if bytes.Contains(saved, []byte("kind")) {
+			t.Fatalf("kind is written to %q: %q", note, saved)
+		}
+	}
+}
+
+// Typed headers only write the keys that changed, zero values of keys the
+// note does not have are left out.
+func TestMinimalDiffTyped(t *testing.T) {
+	tests := []struct {
+		name string
+		note string
+		edit func(*Buffer) error
+		want string
+	}{
+		{
+			name: "question",
+			note: "--\ncreated: 2024-01-01\nkind: question\nquestion: q\n--\nbody\n",
+			edit: func(b *Buffer) error {
+				h, err := GetHeader[api.QuestionHeader](b)
+				if err != nil {
+					return err
+				}
+				h.Question = "changed"
+				return SetHeader(b, h)
+			},
+			want: "--\ncreated: 2024-01-01\nkind: question\nquestion: changed\n--\nbody\n",
+		},
+		{
+			name: "literature",
+			note: "---\nkind: literature\ncreated: \"2024-01-01\"\ncitation_key: smith2020\ntitle: Old\ntags: [paper]\ndoi: \"\"\n---\n",
+			edit: func(b *Buffer) error {
+				h, err := GetHeader[api.LiteratureHeader](b)
+				if err != nil {
+					return err
+				}
+				h.Title = "New"
+				h.Year = "2020"
+				return SetHeader(b, h)
+			},
+			want: "---\nkind: literature\ncreated: \"2024-01-01\"\ncitation_key: smith2020\ntitle: New\ntags: [paper]\ndoi: \"\"\nyear: \"2020\"\n---\n",
+		},
+		{
+			name: "note without a header gets every key",
+			note: "body\n",
+			edit: func(b *Buffer) error {
+				return SetHeader(b, api.PermanentHeader{ID: "x"})
+			},
+			want: "--\ncreated: \"0001-01-01\"\nid: x\nkind: permanent\nlinks: []\nsources: []\ntags: []\ntitle: \"\"\n--\nbody\n",
+		},
+	}
+
+	c, dir := newTestClient(t)
+	path := filepath.Join(dir, "note.md")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			saved, ok := loadAndSave(t, c, path, test.note, func(b *Buffer) {
+				if err := test.edit(b); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if !ok {
+				t.Fatal("cannot read note")
+			}
+			if string(saved) != test.want {
+				t.Fatalf("unexpected save\n%s", diff.Unified("want", "saved", test.want, string(saved)))
+			}
+		})
+	}
+}
+
+func TestValidateHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		note string
+		want []string // errors of the invalid fields
+	}{
+		{
+			name: "valid",
+			note: "---\nkind: question\ncreated: 2024-01-01\nquestion: \"5\"\ntags: [a, b]\n---\n",
+		},
+		{
+			name: "non-string item in a string list",
+			note: "---\nkind: question\ntags: [a, 1]\n---\n",
+			want: []string{"tags: expected []string, got [a 1] ([]interface {})"},
+		},
+		{
+			name: "non-string scalar in a string field",
+			note: "---\nkind: question\nquestion: 5\nfrom: true\n---\n",
+			want: []string{"question: expected string, got 5 (int)", "from: expected string, got true (bool)"},
+		},
+		{
+			name: "date that cannot be parsed",
+			note: "---\nkind: literature\ncreated: someday\nauthors: Smith\n---\n",
+			want: []string{"created: expected datetime.Date, got \"someday\"", "authors: expected []string, got \"Smith\""},
+		},
+		{
+			name: "unknown kind is not checked",
+			note: "---\nkind: recipe\ntags: [a, 1]\n---\n",
+		},
+		{
+			name: "no kind is not checked",
+			note: "---\nquestion: 5\n---\n",
+		},
+	}
+
+	c, dir := newTestClient(t)
+	path := filepath.Join(dir, "note.md")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(test.note), 0644); err != nil {
+				t.Fatal(err)
+			}
+			b, err := c.NewBufferFromFile(path, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = ValidateHeader(b)
+			if len(test.want) == 0 {
+				if err != nil {
+					t.Fatalf("got %v", err)
+				}
+				return
+			}
+			var headerErr *HeaderError
+			if !errors.As(err, &headerErr) {
+				t.Fatalf("got %v, want a *HeaderError", err)
+			}
+			got := []string{}
+			for _, field := range headerErr.Fields {
+				got = append(got, field.Error())
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// Outside strict mode the values that cannot be read are skipped.
+func TestGetHeaderLenient(t *testing.T) {
+	c, dir := newTestClient(t)
+	path := filepath.Join(dir, "note.md")
+	if err := os.WriteFile(path, []byte("---\nkind: question\ncreated: someday\nquestion: 5\ntags: [a, [b]]\n---\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.NewBufferFromFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := GetHeader[api.QuestionHeader](b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.Created.IsZero() || h.Question != "5" || strings.Join(h.Tags, ",") != "a" {
+		t.Fatalf("got %+v", h)
+	}
+}
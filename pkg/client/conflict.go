@@ -0,0 +1,123 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ubombar/soa/pkg/diff"
+)
+
+// Decides what happens when a note changed on disk after it was read.
+type ConflictPolicy string
+
+const (
+	ConflictPolicyFail      ConflictPolicy = "fail"      // keep the note on disk and return a *ConflictError
+	ConflictPolicyMerge     ConflictPolicy = "merge"     // three-way merge both changes, fail if they overlap
+	ConflictPolicyOverwrite ConflictPolicy = "overwrite" // write the buffer over the changes on disk
+)
+
+var (
+	ErrUnknownConflictPolicy = errors.New("unknown conflict policy")
+	ErrConflict              = errors.New("note changed on disk since it was read")
+)
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(s); policy {
+	case ConflictPolicyFail, ConflictPolicyMerge, ConflictPolicyOverwrite:
+		return policy, nil
+	case "":
+		return ConflictPolicyFail, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownConflictPolicy, s)
+	}
+}
+
+// Returned by SaveBuffer when the note changed on disk after it was read and
+// the change could not be merged. Matches ErrConflict with errors.Is.
+type ConflictError struct {
+	Path    string
+	ReadAt  time.Time // modification time of the note when it was read
+	ModTime time.Time // modification time of the note on disk, zero if it was deleted
+	Merged  []byte    // result of the merge with conflict markers, nil if no merge was tried
+}
+
+func (e *ConflictError) Error() string {
+	reason := fmt.Sprintf("modified at %s", e.ModTime.Format(time.DateTime))
+	if e.ModTime.IsZero() {
+		reason = "deleted"
+	}
+	if e.Merged != nil {
+		reason += ", and the changes overlap"
+	}
+	return fmt.Sprintf("%s: %v: %s", e.Path, ErrConflict, reason)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// State of the file a buffer was read from.
+type fileState struct {
+	hash    [sha256.Size]byte
+	modTime time.Time
+	size    int64
+	raw     []byte // contents, the base of three-way merges
+}
+
+func newFileState(raw []byte, info os.FileInfo) *fileState {
+	return &fileState{
+		hash:    sha256.Sum256(raw),
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		raw:     raw,
+	}
+}
+
+// Returns the contents to save in place of data if the file changed on disk
+// since the buffer was read, following the conflict policy.
+func (c *BufferClient) resolveConflict(b *Buffer, data []byte) ([]byte, error) {
+	if b.loaded == nil || c.cfg.conflictPolicy == ConflictPolicyOverwrite {
+		return data, nil
+	}
+
+	info, err := os.Stat(b.Origin)
+	if os.IsNotExist(err) {
+		return nil, &ConflictError{Path: b.Origin, ReadAt: b.loaded.modTime}
+	} else if err != nil {
+		return nil, err
+	}
+	if info.ModTime().Equal(b.loaded.modTime) && info.Size() == b.loaded.size {
+		return data, nil
+	}
+
+	current, err := os.ReadFile(b.Origin)
+	if err != nil {
+		return nil, err
+	}
+	if sha256.Sum256(current) == b.loaded.hash {
+		return data, nil // touched but not changed
+	}
+
+	conflict := &ConflictError{Path: b.Origin, ReadAt: b.loaded.modTime, ModTime: info.ModTime()}
+	if c.cfg.conflictPolicy != ConflictPolicyMerge {
+		return nil, conflict
+	}
+
+	merged, clean := diff.Merge(diff.SplitLines(string(b.loaded.raw)), diff.SplitLines(string(data)), diff.SplitLines(string(current)))
+	result := []byte(strings.Join(merged, ""))
+	if !clean {
+		conflict.Merged = result
+		return nil, conflict
+	}
+
+	// the buffer follows what ends up on disk
+	if err := b.read(bytes.NewReader(result)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const conflictBase = "---\n" +
+	"kind: permanent\n" +
+	"title: Note\n" +
+	"---\n" +
+	"first\n" +
+	"second\n" +
+	"third\n"
+
+func TestConflictPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   ConflictPolicy
+		ours     func(*Buffer) // edit of the buffer
+		theirs   *string       // contents written to disk after the read, nil deletes the note
+		want     string        // note on disk after the save
+		conflict bool          // save fails with a *ConflictError
+		merged   string        // merge result of the conflict, empty if no merge is tried
+	}{
+		{
+			name:     "fail",
+			policy:   ConflictPolicyFail,
+			ours:     func(b *Buffer) { b.Content.WriteString("ours\n") },
+			theirs:   ptr(strings.Replace(conflictBase, "first", "theirs", 1)),
+			want:     strings.Replace(conflictBase, "first", "theirs", 1),
+			conflict: true,
+		},
+		{
+			name:   "overwrite",
+			policy: ConflictPolicyOverwrite,
+			ours:   func(b *Buffer) { b.Content.WriteString("ours\n") },
+			theirs: ptr(strings.Replace(conflictBase, "first", "theirs", 1)),
+			want:   conflictBase + "ours\n",
+		},
+		{
+			name:   "merge",
+			policy: ConflictPolicyMerge,
+			ours:   func(b *Buffer) { b.Header["title"] = "Ours" },
+			theirs: ptr(strings.Replace(conflictBase, "third", "theirs", 1)),
+			want:   strings.Replace(strings.Replace(conflictBase, "third", "theirs", 1), "title: Note", "title: Ours", 1),
+		},
+		{
+			name:     "merge with overlapping changes",
+			policy:   ConflictPolicyMerge,
+			ours:     func(b *Buffer) { b.Content.Reset(); b.Content.WriteString("first\nours\nthird\n") },
+			theirs:   ptr(strings.Replace(conflictBase, "second", "theirs", 1)),
+			want:     strings.Replace(conflictBase, "second", "theirs", 1),
+			conflict: true,
+			merged:   strings.Replace(conflictBase, "second\n", "<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\n", 1),
+		},
+		{
+			name:   "touched without changes",
+			policy: ConflictPolicyFail,
+			ours:   func(b *Buffer) { b.Content.WriteString("ours\n") },
+			theirs: ptr(conflictBase),
+			want:   conflictBase + "ours\n",
+		},
+		{
+			name:     "deleted",
+			policy:   ConflictPolicyMerge,
+			ours:     func(b *Buffer) { b.Content.WriteString("ours\n") },
+			conflict: true,
+		},
+		{
+			name:   "deleted and overwritten",
+			policy: ConflictPolicyOverwrite,
+			ours:   func(b *Buffer) { b.Content.WriteString("ours\n") },
+			want:   conflictBase + "ours\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c, err := NewBufferClient(&BufferClientConfig{soaDir: dir, zoteroDataDir: dir, noLock: true, conflictPolicy: test.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "note.md")
+			if err := os.WriteFile(path, []byte(conflictBase), 0644); err != nil {
+				t.Fatal(err)
+			}
+			b, err := c.NewBufferFromFile(path, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the note changes on disk between the read and the save
+			if test.theirs != nil {
+				if err := os.WriteFile(path, []byte(*test.theirs), 0644); err != nil {
+					t.Fatal(err)
+				}
+				later := time.Now().Add(time.Minute)
+				if err := os.Chtimes(path, later, later); err != nil {
+					t.Fatal(err)
+				}
+			} else if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+
+			test.ours(b)
+			err = c.SaveBuffer(b)
+
+			var conflict *ConflictError
+			switch {
+			case test.conflict && !errors.As(err, &conflict):
+				t.Fatalf("got %v, want a *ConflictError", err)
+			case test.conflict && !errors.Is(err, ErrConflict):
+				t.Fatalf("%v does not match ErrConflict", err)
+			case !test.conflict && err != nil:
+				t.Fatal(err)
+			}
+			if conflict != nil && string(conflict.Merged) != test.merged {
+				t.Errorf("got merge %q, want %q", conflict.Merged, test.merged)
+			}
+
+			saved, err := os.ReadFile(path)
+			if test.theirs == nil && test.conflict {
+				if !os.IsNotExist(err) {
+					t.Fatalf("deleted note is written again: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(saved) != test.want {
+				t.Fatalf("got %q, want %q", saved, test.want)
+			}
+			if test.conflict {
+				return
+			}
+
+			// the buffer follows the note on disk and saves again without a
+			// conflict
+			if data, err := b.Bytes(); err != nil || string(data) != test.want {
+				t.Fatalf("buffer holds %q, want %q: %v", data, test.want, err)
+			}
+			b.Content.WriteString("again\n")
+			if err := c.SaveBuffer(b); err != nil {
+				t.Fatalf("second save: %v", err)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Dialect of the header block at the top of the notes.
+type FrontMatter string
+
+const (
+	FrontMatterSoa  FrontMatter = "soa"  // yaml between -- lines, the original soa format
+	FrontMatterYAML FrontMatter = "yaml" // yaml between --- lines, read by obsidian, hugo, jekyll and pandoc
+	FrontMatterTOML FrontMatter = "toml" // toml between +++ lines, read by hugo
+)
+
+var ErrUnknownFrontMatter = errors.New("unknown front matter")
+
+func ParseFrontMatter(s string) (FrontMatter, error) {
+	switch frontMatter := FrontMatter(s); frontMatter {
+	case FrontMatterSoa, FrontMatterYAML, FrontMatterTOML:
+		return frontMatter, nil
+	case "":
+		return FrontMatterSoa, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownFrontMatter, s)
+	}
+}
+
+// Returns the line opening and closing the header of the dialect.
+func (f FrontMatter) delimiter() string {
+	switch f {
+	case FrontMatterYAML:
+		return "---"
+	case FrontMatterTOML:
+		return "+++"
+	default:
+		return headerSeperator
+	}
+}
+
+// Returns the dialect opened by the line, false if the line does not open a
+// header.
+func frontMatterOf(line string) (FrontMatter, bool) {
+	for _, f := range []FrontMatter{FrontMatterSoa, FrontMatterYAML, FrontMatterTOML} {
+		if line == f.delimiter() {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// Reports whether the line closes a header of the dialect, yaml documents
+// can also end with ...
+func (f FrontMatter) closes(line string) bool {
+	return line == f.delimiter() || (f == FrontMatterYAML && line == "...")
+}
+
+// Parses a toml header into a yaml document, so that it is handled like the
+// yaml headers.
+func tomlDocument(header []byte) (*yaml.Node, error) {
+	values := map[string]any{}
+	if err := toml.Unmarshal(header, &values); err != nil {
+		return nil, err
+	}
+
+	mapping := new(yaml.Node)
+	if err := mapping.Encode(fromTOML(values)); err != nil {
+		return nil, err
+	}
+	return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{mapping}}, nil
+}
+
+// Replaces the local date and time values of toml with their text, as yaml
+// has no such types.
+func fromTOML(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = fromTOML(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = fromTOML(item)
+		}
+	case toml.LocalDate, toml.LocalDateTime, toml.LocalTime:
+		return fmt.Sprint(v)
+	}
+	return v
+}
+
+// Encodes the header document as toml. Nulls are dropped and map keys are
+// turned into strings as toml has neither.
+func tomlHeader(document *yaml.Node) ([]byte, error) {
+	var values any
+	if err := document.Decode(&values); err != nil {
+		return nil, err
+	}
+	if values == nil {
+		return nil, nil // empty header
+	}
+	return toml.Marshal(toTOML(values))
+}
+
+func toTOML(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := map[string]any{}
+		for key, item := range v {
+			if item != nil {
+				out[key] = toTOML(item)
+			}
+		}
+		return out
+	case map[any]any:
+		out := map[string]any{}
+		for key, item := range v {
+			if item != nil {
+				out[fmt.Sprint(key)] = toTOML(item)
+			}
+		}
+		return out
+	case []any:
+		out := make([]any, 0, len(v))
+		for _, item := range v {
+			if item != nil {
+				out = append(out, toTOML(item))
+			}
+		}
+		return out
+	case time.Time:
+		// dates without time, as yaml reads them, are written as toml dates
+		if v.Equal(v.Truncate(24*time.Hour)) && v.Location() == time.UTC {
+			return toml.LocalDate{Year: v.Year(), Month: int(v.Month()), Day: v.Day()}
+		}
+	}
+	return v
+}
+
+var ErrLossyFrontMatter = errors.New("header would change in the conversion")
+
+// Returns the buffer written in the given dialect, the buffer is written in
+// it from then on. The result is read back and compared with the buffer, the
+// conversion fails with ErrLossyFrontMatter if the header or the content
+// would change, e.g. as toml has no nulls.
+func (b *Buffer) ConvertFrontMatter(to FrontMatter) ([]byte, error) {
+	b.SetFrontMatter(to)
+	out, err := b.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	check := &Buffer{}
+	if err := check.read(bytes.NewReader(out)); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(check.Content.Bytes(), b.Content.Bytes()) {
+		return nil, fmt.Errorf("%w: content", ErrLossyFrontMatter)
+	}
+
+	changed := []string{}
+	for key, value := range b.Header {
+		if checkValue, ok := check.Header[key]; !ok || !reflect.DeepEqual(normalizeHeaderValue(value), normalizeHeaderValue(checkValue)) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range check.Header {
+		if _, ok := b.Header[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	if len(changed) != 0 {
+		sort.Strings(changed)
+		return nil, fmt.Errorf("%w: %s", ErrLossyFrontMatter, strings.Join(changed, ", "))
+	}
+	return out, nil
+}
+
+// Returns the header value in a form that does not depend on the dialect it
+// was read from, numbers and dates are compared by their text.
+func normalizeHeaderValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := map[string]any{}
+		for key, item := range v {
+			out[key] = normalizeHeaderValue(item)
+		}
+		return out
+	case map[any]any:
+		out := map[string]any{}
+		for key, item := range v {
+			out[fmt.Sprint(key)] = normalizeHeaderValue(item)
+		}
+		return out
+	case []any:
+		out := make([]any, 0, len(v))
+		for _, item := range v {
+			out = append(out, normalizeHeaderValue(item))
+		}
+		return out
+	case time.Time:
+		if v.Equal(v.Truncate(24*time.Hour)) && v.Location() == time.UTC {
+			return v.Format("2006-01-02")
+		}
+		return v.Format(time.RFC3339Nano)
+	case nil, string, bool:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
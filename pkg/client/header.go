@@ -0,0 +1,385 @@
+package client
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Returns the mapping node of the header document, nil if the header is
+// empty or not a mapping.
+func (b *Buffer) headerMapping() *yaml.Node {
+	if b.document == nil || b.document.Kind != yaml.DocumentNode || len(b.document.Content) != 1 {
+		return nil
+	}
+	if mapping := b.document.Content[0]; mapping.Kind == yaml.MappingNode {
+		return mapping
+	}
+	return nil
+}
+
+// Returns the value node of the key in the header document.
+func (b *Buffer) documentNode(key string) (*yaml.Node, bool) {
+	mapping := b.headerMapping()
+	if mapping == nil {
+		return nil, false
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1], true
+		}
+	}
+	return nil, false
+}
+
+// Returns the yaml node of the header value. The node read from the file is
+// used as long as the value is not changed, so unmarshalers see the value as
+// it was written.
+func (b *Buffer) headerNode(key string, val any) (*yaml.Node, error) {
+	if node, ok := b.documentNode(key); ok && nodeHolds(node, val) {
+		return node, nil
+	}
+
+	node := new(yaml.Node)
+	if err := node.Encode(val); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// Returns a copy of the header document in line with the header map, the
+// document as read is left untouched. Keys whose values did not change keep
+// their node with its comments and style, changed values are replaced in
+// place, removed keys are dropped and new keys are appended in sorted order.
+func (b *Buffer) updateDocument() *yaml.Node {
+	var document *yaml.Node
+	mapping := b.headerMapping()
+	if mapping == nil {
+		mapping = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		document = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{mapping}}
+	} else {
+		document = copyNode(b.document)
+		mapping = document.Content[0]
+	}
+
+	present := map[string]bool{}
+	content := make([]*yaml.Node, 0, len(mapping.Content))
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		keyNode, valueNode := mapping.Content[i], mapping.Content[i+1]
+		present[keyNode.Value] = true
+
+		val, ok := b.Header[keyNode.Value]
+		if !ok {
+			if hiddenNode(valueNode) {
+				content = append(content, keyNode, valueNode)
+			}
+			continue // removed from the header
+		}
+		if !nodeHolds(valueNode, val) {
+			replaceNode(valueNode, val)
+		}
+		content = append(content, keyNode, valueNode)
+	}
+
+	for _, key := range b.newKeys(present) {
+		keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+		valueNode := new(yaml.Node)
+		replaceNode(valueNode, b.Header[key])
+		content = append(content, keyNode, valueNode)
+	}
+
+	mapping.Content = content
+	return document
+}
+
+// Returns a deep copy of the header value, so that changes to nested lists
+// and maps are seen.
+func copyValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, item := range v {
+			out[key] = copyValue(item)
+		}
+		return out
+	case map[any]any:
+		out := make(map[any]any, len(v))
+		for key, item := range v {
+			out[key] = copyValue(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = copyValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// Returns a deep copy of the node.
+func copyNode(node *yaml.Node) *yaml.Node {
+	out := *node
+	out.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		out.Content[i] = copyNode(child)
+	}
+	return &out
+}
+
+// Reports whether the node still holds the value, either because it decodes
+// to the value or because the value encodes to the same yaml.
+func nodeHolds(node *yaml.Node, val any) bool {
+	var decoded any
+	if err := node.Decode(&decoded); err == nil && reflect.DeepEqual(decoded, val) {
+		return true
+	}
+	encoded := new(yaml.Node)
+	if err := encoded.Encode(val); err != nil {
+		return false
+	}
+	return sameNode(node, encoded)
+}
+
+// Reports whether the nodes hold the same yaml ignoring tags, styles and
+// comments, so that a date written without quotes equals the same date set
+// from a typed header.
+func sameNode(a *yaml.Node, b *yaml.Node) bool {
+	if a.Kind == yaml.DocumentNode || a.Kind == yaml.AliasNode {
+		return false
+	}
+	if a.Kind != b.Kind || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !sameNode(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Replaces the node with the encoded value, comments of the node are kept.
+// Values that cannot be encoded become null.
+func replaceNode(node *yaml.Node, val any) {
+	encoded := new(yaml.Node)
+	if err := encoded.Encode(val); err != nil {
+		encoded = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	}
+	encoded.HeadComment = node.HeadComment
+	encoded.LineComment = node.LineComment
+	encoded.FootComment = node.FootComment
+	*node = *encoded
+}
+
+// Reports whether the node holds a value the header map cannot, see
+// Buffer.read.
+func hiddenNode(node *yaml.Node) bool {
+	var decoded any
+	if err := node.Decode(&decoded); err != nil {
+		return true
+	}
+	_, ok := decoded.(map[interface{}]interface{})
+	return ok
+}
+
+// Returns the keys of the header map that are not in the header as read,
+// sorted. The kind set on read is left out.
+func (b *Buffer) newKeys(present map[string]bool) []string {
+	keys := []string{}
+	for key := range b.Header {
+		if present[key] || (key == "kind" && b.kindInjected && b.Header[key] == UnknownKind) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Reports whether the header map differs from the header as read.
+func (b *Buffer) headerChanged() bool {
+	if b.headerAsRead != nil && reflect.DeepEqual(b.Header, b.headerAsRead) {
+		return false
+	}
+
+	present := map[string]bool{}
+	if mapping := b.headerMapping(); mapping != nil {
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			keyNode, valueNode := mapping.Content[i], mapping.Content[i+1]
+			present[keyNode.Value] = true
+
+			val, ok := b.Header[keyNode.Value]
+			if (!ok && !hiddenNode(valueNode)) || (ok && !nodeHolds(valueNode, val)) {
+				return true
+			}
+		}
+	} else if !emptyDocument(b.document) {
+		return true // scalar or list header, rewritten as a mapping
+	}
+	return len(b.newKeys(present)) != 0
+}
+
+// Returns the yaml header with the entries whose values did not change copied
+// from the header as read, so only the changed entries differ. Removed
+// entries are dropped and new ones are appended in sorted order. Headers that
+// cannot be split into entries, such as flow mappings, are marshalled as a
+// whole.
+func (b *Buffer) spliceHeader() ([]byte, error) {
+	lines := strings.SplitAfter(string(b.rawHeader), "\n")
+	mapping := b.headerMapping()
+	var spans []entrySpan
+	ok := b.document == nil || len(b.document.Content) == 0
+	if mapping != nil {
+		spans, ok = entrySpans(lines, mapping)
+	}
+	indent := headerIndent(lines)
+	if !ok {
+		return yaml.Marshal(b.updateDocument())
+	}
+
+	var out bytes.Buffer
+	present := map[string]bool{}
+	pos := 0
+	for i, span := range spans {
+		keyNode, valueNode := mapping.Content[2*i], mapping.Content[2*i+1]
+		present[keyNode.Value] = true
+
+		out.WriteString(strings.Join(lines[pos:span.start], "")) // comments and blank lines before the entry
+		pos = span.end
+
+		val, ok := b.Header[keyNode.Value]
+		switch {
+		case !ok && !hiddenNode(valueNode):
+			continue // removed from the header
+		case !ok || nodeHolds(valueNode, val):
+			out.WriteString(strings.Join(lines[span.start:span.end], ""))
+		default:
+			entry, err := marshalEntry(keyNode, valueNode, val, indent)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(entry)
+		}
+	}
+	out.WriteString(strings.Join(lines[pos:], ""))
+
+	if out.Len() != 0 && !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+		out.WriteByte('\n')
+	}
+	for _, key := range b.newKeys(present) {
+		entry, err := marshalEntry(&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, nil, b.Header[key], indent)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(entry)
+	}
+	return out.Bytes(), nil
+}
+
+// Lines of a top level header entry, from start up to but excluding end.
+type entrySpan struct {
+	start int
+	end   int
+}
+
+// Returns the lines of each entry of the header mapping. Blank lines and
+// comments at the start of a line that follow an entry are left out, so
+// they stay in place when the entry is rewritten. Fails if the entries do not
+// start on their own lines or refer to each other.
+func entrySpans(lines []string, mapping *yaml.Node) ([]entrySpan, bool) {
+	if mapping.Style&yaml.FlowStyle != 0 || hasAnchors(mapping) {
+		return nil, false // rewriting one entry could break the others
+	}
+
+	spans := make([]entrySpan, 0, len(mapping.Content)/2)
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		keyNode := mapping.Content[i]
+		start := keyNode.Line - 1
+		if keyNode.Column != 1 || start < 0 || start >= len(lines) {
+			return nil, false
+		}
+		if n := len(spans); n != 0 && spans[n-1].start >= start {
+			return nil, false
+		}
+		spans = append(spans, entrySpan{start: start})
+	}
+
+	for i := range spans {
+		end := len(lines)
+		if i+1 < len(spans) {
+			end = spans[i+1].start
+		}
+		for end > spans[i].start+1 && gapLine(lines[end-1]) {
+			end--
+		}
+		spans[i].end = end
+	}
+	return spans, true
+}
+
+// Reports whether the node or its children use anchors or aliases.
+func hasAnchors(node *yaml.Node) bool {
+	if node.Anchor != "" || node.Kind == yaml.AliasNode {
+		return true
+	}
+	for _, child := range node.Content {
+		if hasAnchors(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the line is blank or a comment starting the line.
+func gapLine(line string) bool {
+	return strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#")
+}
+
+// Reports whether the yaml document has no content besides comments or is
+// null, which is read as an empty header.
+func emptyDocument(document *yaml.Node) bool {
+	return document == nil || len(document.Content) == 0 || document.Content[0].Tag == "!!null"
+}
+
+// Returns the indentation of the nested values in the header lines, 4 as
+// written by yaml.Marshal if there are none.
+func headerIndent(lines []string) int {
+	for _, line := range lines {
+		if gapLine(line) {
+			continue
+		}
+		if indent := len(line) - len(strings.TrimLeft(line, " ")); indent >= 2 && indent <= 8 {
+			return indent
+		}
+	}
+	return 4
+}
+
+// Marshals a single header entry with the given indentation. The comment on
+// the line of the old value is kept, comments around the entry are kept by
+// spliceHeader.
+func marshalEntry(keyNode *yaml.Node, old *yaml.Node, val any, indent int) ([]byte, error) {
+	key := &yaml.Node{Kind: keyNode.Kind, Tag: keyNode.Tag, Value: keyNode.Value, Style: keyNode.Style, LineComment: keyNode.LineComment}
+	value := new(yaml.Node)
+	replaceNode(value, val)
+	if old != nil {
+		value.LineComment = old.LineComment
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(indent)
+	if err := encoder.Encode(&yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{key, value}}); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
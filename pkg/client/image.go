@@ -0,0 +1,76 @@
+package client
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ubombar/soa/api"
+	"github.com/ubombar/soa/internal/log"
+	"github.com/ubombar/soa/internal/util"
+)
+
+// Renders image and ink annotations. The image rendered by Zotero is copied
+// from its cache into the attachements folder of the vault and given to the
+// template as a path relative to the literature notes.
+type ImageRenderer struct {
+	renderer       *TemplateRenderer
+	cacheDir       string // folder zotero keeps the rendered annotation images
+	attachementDir string // folder of the vault the images are copied to
+	noteDir        string // folder of the notes the image is linked from
+}
+
+func NewImageRenderer(renderer *TemplateRenderer, cacheDir string, attachementDir string, noteDir string) *ImageRenderer {
+	return &ImageRenderer{
+		renderer:       renderer,
+		cacheDir:       cacheDir,
+		attachementDir: attachementDir,
+		noteDir:        noteDir,
+	}
+}
+
+func (r *ImageRenderer) Render(w io.Writer, annot api.ZoteroAnnotation) error {
+	logger := log.GlobalLogger
+	data := AnnotationData{ZoteroAnnotation: annot}
+
+	imagePath, err := r.export(annot)
+	if err != nil {
+		return err
+	}
+	if imagePath == "" {
+		logger.Warnf("no rendered image for %s annotation %s, open it in zotero to render it.\n", annot.AnnotationType, annot.Key)
+	} else {
+		relPath, err := filepath.Rel(r.noteDir, imagePath)
+		if err != nil {
+			return err
+		}
+		data.Image = filepath.ToSlash(relPath)
+	}
+
+	return r.renderer.Execute(w, data)
+}
+
+// Reports whether the image of the annotation was copied into the vault.
+func (r *ImageRenderer) Exported(annot api.ZoteroAnnotation) bool {
+	return util.FileExists(filepath.Join(r.attachementDir, annot.Key+".png"))
+}
+
+// Copies the rendered image of the annotation into the vault, returns empty
+// string if zotero did not render the annotation yet.
+func (r *ImageRenderer) export(annot api.ZoteroAnnotation) (string, error) {
+	name := annot.Key + ".png"
+	src := filepath.Join(r.cacheDir, name)
+	if !util.FileExists(src) {
+		return "", nil
+	}
+
+	if err := os.MkdirAll(r.attachementDir, 0755); err != nil {
+		return "", err
+	}
+
+	dst := filepath.Join(r.attachementDir, name)
+	if err := util.CopyFile(src, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
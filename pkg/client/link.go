@@ -0,0 +1,301 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	backlinksBeginMarker = "<!-- soa:backlinks:begin -->"
+	backlinksEndMarker   = "<!-- soa:backlinks:end -->"
+)
+
+type LinkType string
+
+const (
+	WikiLink     LinkType = "wiki"     // [[target#anchor|alias]]
+	MarkdownLink LinkType = "markdown" // [alias](target#anchor)
+	HeaderLink   LinkType = "header"   // value of a header key such as from
+)
+
+// Header keys whose values point to other notes.
+var HeaderLinkKeys = []string{"from", "sources", "links"}
+
+// Reference from a note to another note or file.
+type Link struct {
+	Type   LinkType `json:"type"`
+	Target string   `json:"target"`           // note name or path as written, without the anchor
+	Anchor string   `json:"anchor,omitempty"` // heading or block after #
+	Alias  string   `json:"alias,omitempty"`  // displayed text
+	Embed  bool     `json:"embed,omitempty"`  // written with a leading !
+	Key    string   `json:"key,omitempty"`    // header key of header links
+	Line   int      `json:"line,omitempty"`   // line in the content, starts from 1
+}
+
+// Reports whether the link points to a note rather than an attachement.
+func (l Link) IsNote() bool {
+	ext := path.Ext(l.Target)
+	return ext == "" || ext == ".md"
+}
+
+var (
+	wikiLinkRegexp     = regexp.MustCompile(`(!?)\[\[([^\[\]|#]*)(?:#([^\[\]|]*))?(?:\|([^\[\]]*))?\]\]`)
+	markdownLinkRegexp = regexp.MustCompile(`(!?)\[([^\[\]]*)\]\(\s*(<[^<>]*>|[^()\s]*)(?:\s+"[^"]*")?\s*\)`)
+	inlineCodeRegexp   = regexp.MustCompile("`[^`\n]*`")
+	schemeRegexp       = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+)
+
+// Parses the wiki links and the markdown links to local files in the content.
+// Links in code and in the managed backlinks section are ignored.
+func ParseLinks(content []byte) []Link {
+	links := []Link{}
+	scanLinks(content, true, func(link Link, start int, end int) {
+		links = append(links, link)
+	})
+	return links
+}
+
+// Calls fn with every link of the content and the offsets of its target in
+// the content. For markdown links the target offsets include the anchor.
+func scanLinks(content []byte, skipBacklinks bool, fn func(link Link, start int, end int)) {
+	// the backlinks section only mirrors the links of other notes
+	if start, stop, ok := findRegion(content, backlinksBeginMarker, backlinksEndMarker); ok && skipBacklinks {
+		content = bytes.Clone(content)
+		for i := start; i < stop; i++ {
+			if content[i] != '\n' {
+				content[i] = ' ' // keep the line numbers and offsets
+			}
+		}
+	}
+
+	inFence := false
+	offset := 0
+	for i, line := range strings.Split(string(content), "\n") {
+		lineOffset := offset
+		offset += len(line) + 1
+
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+		line = inlineCodeRegexp.ReplaceAllStringFunc(line, func(code string) string {
+			return strings.Repeat(" ", len(code))
+		})
+
+		for _, match := range wikiLinkRegexp.FindAllStringSubmatchIndex(line, -1) {
+			target := strings.TrimSpace(line[match[4]:match[5]])
+			if target == "" {
+				continue // link to a heading of the same note
+			}
+			fn(Link{
+				Type:   WikiLink,
+				Target: target,
+				Anchor: submatch(line, match, 3),
+				Alias:  submatch(line, match, 4),
+				Embed:  submatch(line, match, 1) == "!",
+				Line:   i + 1,
+			}, lineOffset+match[4], lineOffset+match[5])
+		}
+
+		for _, match := range markdownLinkRegexp.FindAllStringSubmatchIndex(line, -1) {
+			rawTarget := strings.TrimSuffix(strings.TrimPrefix(line[match[6]:match[7]], "<"), ">")
+			if rawTarget == "" || strings.HasPrefix(rawTarget, "#") || schemeRegexp.MatchString(rawTarget) {
+				continue // same note, web or mail links
+			}
+			target, anchor, _ := strings.Cut(rawTarget, "#")
+			if unescaped, err := url.PathUnescape(target); err == nil {
+				target = unescaped
+			}
+			fn(Link{
+				Type:   MarkdownLink,
+				Target: target,
+				Anchor: anchor,
+				Alias:  submatch(line, match, 2),
+				Embed:  submatch(line, match, 1) == "!",
+				Line:   i + 1,
+			}, lineOffset+match[6], lineOffset+match[7])
+		}
+	}
+}
+
+// Returns the n-th submatch given the indexes of the match, empty if the
+// group did not participate.
+func submatch(s string, match []int, n int) string {
+	if match[2*n] < 0 {
+		return ""
+	}
+	return s[match[2*n]:match[2*n+1]]
+}
+
+// Replaces the targets of the links in the content with the ones returned by
+// rewrite, links are left alone if it returns false. Anchors, aliases and the
+// way the target is written are kept. Links in the backlinks section are
+// rewritten as well. Returns false if nothing changed.
+func RewriteLinks(content []byte, rewrite func(link Link) (string, bool)) ([]byte, bool) {
+	type replacement struct {
+		start, end int
+		text       string
+	}
+	replacements := []replacement{}
+
+	scanLinks(content, false, func(link Link, start int, end int) {
+		target, ok := rewrite(link)
+		if !ok || target == link.Target {
+			return
+		}
+		text := target
+		if link.Type == MarkdownLink {
+			if bytes.HasPrefix(content[start:end], []byte("<")) {
+				text = "<" + target
+			} else {
+				text = (&url.URL{Path: target}).EscapedPath()
+			}
+			if link.Anchor != "" {
+				text += "#" + link.Anchor
+			}
+			if bytes.HasPrefix(content[start:end], []byte("<")) {
+				text += ">"
+			}
+		}
+		replacements = append(replacements, replacement{start: start, end: end, text: text})
+	})
+	if len(replacements) == 0 {
+		return content, false
+	}
+	// wiki links of a line are scanned before its markdown links
+	sort.Slice(replacements, func(i, j int) bool {
+		return replacements[i].start < replacements[j].start
+	})
+
+	rewritten := make([]byte, 0, len(content))
+	last := 0
+	for _, r := range replacements {
+		rewritten = append(rewritten, content[last:r.start]...)
+		rewritten = append(rewritten, r.text...)
+		last = r.end
+	}
+	rewritten = append(rewritten, content[last:]...)
+	return rewritten, true
+}
+
+// Returns the links written as header values, see HeaderLinkKeys. Values can
+// be plain note names, paths or wiki links.
+func ParseHeaderLinks(header map[string]any) []Link {
+	links := []Link{}
+	for _, key := range HeaderLinkKeys {
+		for _, value := range headerLinkValues(header[key]) {
+			if link, ok := parseHeaderLink(key, value); ok {
+				links = append(links, link)
+			}
+		}
+	}
+	return links
+}
+
+// Replaces the targets of the header links with the ones returned by
+// rewrite, see RewriteLinks. Returns false if nothing changed.
+func RewriteHeaderLinks(header map[string]any, rewrite func(link Link) (string, bool)) bool {
+	changed := false
+	rewriteValue := func(key string, value string) string {
+		link, ok := parseHeaderLink(key, value)
+		if !ok {
+			return value
+		}
+		target, ok := rewrite(link)
+		if !ok || target == link.Target {
+			return value
+		}
+		changed = true
+		return strings.Replace(value, link.Target, target, 1)
+	}
+
+	for _, key := range HeaderLinkKeys {
+		switch v := header[key].(type) {
+		case string:
+			header[key] = rewriteValue(key, v)
+		case []any:
+			for i, item := range v {
+				if s, ok := item.(string); ok {
+					v[i] = rewriteValue(key, s)
+				}
+			}
+		}
+	}
+	return changed
+}
+
+func headerLinkValues(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := []string{}
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func parseHeaderLink(key string, value string) (Link, bool) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "[["), "]]")
+	target, alias, _ := strings.Cut(value, "|")
+	target, anchor, _ := strings.Cut(target, "#")
+	if target == "" {
+		return Link{}, false
+	}
+	return Link{Type: HeaderLink, Target: target, Anchor: anchor, Alias: alias, Key: key}, true
+}
+
+// Returns the links of the buffer, header links come first.
+func (b *Buffer) Links() []Link {
+	return append(ParseHeaderLinks(b.Header), ParseLinks(b.Content.Bytes())...)
+}
+
+// Reports whether the buffer has a managed backlinks section.
+func (b *Buffer) HasBacklinks() bool {
+	_, _, ok := findRegion(b.Content.Bytes(), backlinksBeginMarker, backlinksEndMarker)
+	return ok
+}
+
+// Rewrites the managed backlinks section with wiki links to the given note
+// names, the section is appended if it does not exist. Returns false if the
+// section is already up to date.
+func (b *Buffer) SetBacklinks(names []string) bool {
+	inner := new(bytes.Buffer)
+	inner.WriteString("## Backlinks\n\n")
+	for _, name := range names {
+		fmt.Fprintf(inner, "- [[%s]]\n", name)
+	}
+	if len(names) == 0 {
+		inner.WriteString("No backlinks.\n")
+	}
+
+	existing := getRegion(b.Content.Bytes(), backlinksBeginMarker, backlinksEndMarker)
+	if existing != nil && bytes.Equal(existing, inner.Bytes()) {
+		return false
+	}
+
+	// keep a blank line before a new section
+	content := bytes.Clone(b.Content.Bytes())
+	if existing == nil && len(content) != 0 {
+		for !bytes.HasSuffix(content, []byte("\n\n")) {
+			content = append(content, '\n')
+		}
+	}
+	b.Content = replaceRegion(content, backlinksBeginMarker, backlinksEndMarker, inner.Bytes())
+	return true
+}
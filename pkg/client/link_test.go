@@ -0,0 +1,237 @@
+package client
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []Link
+	}{
+		{
+			name:    "wiki link",
+			content: "See [[Note]].\n",
+			want:    []Link{{Type: WikiLink, Target: "Note", Line: 1}},
+		},
+		{
+			name:    "wiki link with heading and alias",
+			content: "\n[[folder/Note#Part two|the note]] and ![[image.png]]\n",
+			want: []Link{
+				{Type: WikiLink, Target: "folder/Note", Anchor: "Part two", Alias: "the note", Line: 2},
+				{Type: WikiLink, Target: "image.png", Embed: true, Line: 2},
+			},
+		},
+		{
+			name:    "heading of the same note",
+			content: "[[#Heading]] and [same](#heading)\n",
+			want:    []Link{},
+		},
+		{
+			name:    "markdown links",
+			content: "[a](Other.md) [b](../dir/My%20Note.md#part \"title\") ![c](<img/a b.png>)\n",
+			want: []Link{
+				{Type: MarkdownLink, Target: "Other.md", Alias: "a", Line: 1},
+				{Type: MarkdownLink, Target: "../dir/My Note.md", Anchor: "part", Alias: "b", Line: 1},
+				{Type: MarkdownLink, Target: "img/a b.png", Alias: "c", Embed: true, Line: 1},
+			},
+		},
+		{
+			name:    "web and mail links",
+			content: "[web](https://example.com) [mail](mailto:a@b.c)\n",
+			want:    []Link{},
+		},
+		{
+			name:    "code",
+			content: "`[[Inline]]` [[Real]]\n```\n[[Fenced]]\n[a](b.md)\n```\n~~~\n[[Tilde]]\n~~~\n",
+			want:    []Link{{Type: WikiLink, Target: "Real", Line: 1}},
+		},
+		{
+			name: "backlinks section",
+			content: "[[Before]]\n" +
+				backlinksBeginMarker + "\n" +
+				"- [[Listed]]\n" +
+				backlinksEndMarker + "\n" +
+				"[[After]]\n",
+			want: []Link{
+				{Type: WikiLink, Target: "Before", Line: 1},
+				{Type: WikiLink, Target: "After", Line: 5},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ParseLinks([]byte(test.content)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRewriteLinks(t *testing.T) {
+	rewrite := func(link Link) (string, bool) {
+		switch link.Target {
+		case "Old":
+			return "New", true
+		case "dir/Old.md", "../dir/Old.md":
+			return strings.Replace(link.Target, "Old.md", "New Name.md", 1), true
+		case "dir/Old Name.md":
+			return "dir/New.md", true
+		}
+		return "", false
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "wiki links keep anchor, alias and embed",
+			content: "[[Old]] [[Old#Part|alias]] ![[Old]] [[Other]]\n",
+			want:    "[[New]] [[New#Part|alias]] ![[New]] [[Other]]\n",
+		},
+		{
+			name:    "markdown links are escaped",
+			content: "[a](dir/Old.md) [b](../dir/Old.md#part \"title\")\n",
+			want:    "[a](dir/New%20Name.md) [b](../dir/New%20Name.md#part \"title\")\n",
+		},
+		{
+			name:    "angle brackets are kept",
+			content: "[a](<dir/Old Name.md#part>) [b](dir/Old%20Name.md)\n",
+			want:    "[a](<dir/New.md#part>) [b](dir/New.md)\n",
+		},
+		{
+			name:    "code is left alone",
+			content: "`[[Old]]` [[Old]]\n```\n[[Old]]\n```\n",
+			want:    "`[[Old]]` [[New]]\n```\n[[Old]]\n```\n",
+		},
+		{
+			name:    "backlinks section is rewritten",
+			content: backlinksBeginMarker + "\n- [[Old]]\n" + backlinksEndMarker + "\n",
+			want:    backlinksBeginMarker + "\n- [[New]]\n" + backlinksEndMarker + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, changed := RewriteLinks([]byte(test.content), rewrite)
+			if string(got) != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			if changed != (test.content != test.want) {
+				t.Errorf("got changed %v", changed)
+			}
+		})
+	}
+
+	content := []byte("[[Other]] [a](b.md)\n")
+	if got, changed := RewriteLinks(content, rewrite); changed || string(got) != string(content) {
+		t.Errorf("unrelated links are rewritten: %q", got)
+	}
+}
+
+func TestHeaderLinks(t *testing.T) {
+	header := map[string]any{
+		"from":    "[[Old#Part|alias]]",
+		"sources": []any{"Old", "Other", 5},
+		"links":   []any{"[[dir/Old Name.md]]"},
+		"title":   "Old",
+	}
+
+	want := []Link{
+		{Type: HeaderLink, Target: "Old", Anchor: "Part", Alias: "alias", Key: "from"},
+		{Type: HeaderLink, Target: "Old", Key: "sources"},
+		{Type: HeaderLink, Target: "Other", Key: "sources"},
+		{Type: HeaderLink, Target: "dir/Old Name.md", Key: "links"},
+	}
+	if got := ParseHeaderLinks(header); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	changed := RewriteHeaderLinks(header, func(link Link) (string, bool) {
+		switch link.Target {
+		case "Old":
+			return "New", true
+		case "dir/Old Name.md":
+			return "dir/New.md", true
+		}
+		return "", false
+	})
+	if !changed {
+		t.Fatal("header links are not rewritten")
+	}
+	wantHeader := map[string]any{
+		"from":    "[[New#Part|alias]]",
+		"sources": []any{"New", "Other", 5},
+		"links":   []any{"[[dir/New.md]]"},
+		"title":   "Old",
+	}
+	if !reflect.DeepEqual(header, wantHeader) {
+		t.Fatalf("got %v, want %v", header, wantHeader)
+	}
+}
+
+func TestSetBacklinks(t *testing.T) {
+	section := func(lines ...string) string {
+		return backlinksBeginMarker + "\n## Backlinks\n\n" + strings.Join(lines, "") + backlinksEndMarker + "\n"
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		names   []string
+		want    string
+		changed bool
+	}{
+		{
+			name:    "appended after a blank line",
+			content: "# Note\ntext\n",
+			names:   []string{"A", "B"},
+			want:    "# Note\ntext\n\n" + section("- [[A]]\n", "- [[B]]\n"),
+			changed: true,
+		},
+		{
+			name:    "empty note",
+			content: "",
+			names:   nil,
+			want:    section("No backlinks.\n"),
+			changed: true,
+		},
+		{
+			name:    "replaced in place",
+			content: "before\n\n" + section("- [[A]]\n") + "after\n",
+			names:   []string{"B"},
+			want:    "before\n\n" + section("- [[B]]\n") + "after\n",
+			changed: true,
+		},
+		{
+			name:    "up to date",
+			content: "text\n\n" + section("- [[A]]\n"),
+			names:   []string{"A"},
+			want:    "text\n\n" + section("- [[A]]\n"),
+		},
+	}
+
+	c, _ := newTestClient(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := c.NewBuffer()
+			b.Content.WriteString(test.content)
+			if changed := b.SetBacklinks(test.names); changed != test.changed {
+				t.Errorf("got changed %v", changed)
+			}
+			if got := b.Content.String(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			if !b.HasBacklinks() {
+				t.Error("backlinks section is not found")
+			}
+			if links := ParseLinks(b.Content.Bytes()); len(links) != 0 {
+				t.Errorf("links of the section are parsed: %+v", links)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	lockPollInterval = 100 * time.Millisecond // interval between attempts to take a held lock
+	lockWriteTimeout = 5 * time.Second        // time given to write the owner of a new lock file
+)
+
+// Hard links the lock file into place, replaced in tests.
+var linkLockFile = os.Link
+
+var ErrVaultLocked = errors.New("vault is locked by another soa process")
+
+// Contents of the lock file.
+type lockOwner struct {
+	PID      int       `json:"pid"`
+	Host     string    `json:"host"`
+	Command  string    `json:"command"`
+	Acquired time.Time `json:"acquired"`
+}
+
+// Returned when the vault lock cannot be taken before the timeout. Matches
+// ErrVaultLocked with errors.Is.
+type LockedError struct {
+	Path  string
+	Owner lockOwner
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%v: pid %d on %s running %q since %s, remove %s if it is not running",
+		ErrVaultLocked, e.Owner.PID, e.Owner.Host, e.Owner.Command, e.Owner.Acquired.Format(time.DateTime), e.Path)
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrVaultLocked
+}
+
+// Advisory lock of the vault, a file holding the pid of the soa process that
+// writes to the vault. Locks of processes that are no longer running on this
+// host are taken over.
+type vaultLock struct {
+	path    string
+	timeout time.Duration
+	held    int // number of nested Lock calls
+}
+
+// Takes the vault lock for the mutating operations of the client, waiting up
+// to the configured timeout for other soa processes. Fails with a
+// *LockedError if the lock is still held. Calls can be nested, the lock is
+// released by the last Unlock. Does nothing if locking is disabled.
+func (c *BufferClient) Lock() error {
+	if c.lock == nil {
+		return nil
+	}
+	return c.lock.acquire()
+}
+
+// Releases the vault lock taken by Lock.
+func (c *BufferClient) Unlock() error {
+	if c.lock == nil {
+		return nil
+	}
+	return c.lock.release()
+}
+
+func (l *vaultLock) acquire() error {
+	if l.held != 0 {
+		l.held++
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(l.timeout)
+	for {
+		err := l.create()
+		if err == nil {
+			l.held = 1
+			return nil
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		owner, raw, err := l.owner()
+		if os.IsNotExist(err) {
+			continue // released in between
+		} else if err != nil {
+			return err
+		}
+		if l.stale(owner) {
+			// only remove the lock if it was not taken over in between
+			if current, err := os.ReadFile(l.path); err == nil && bytes.Equal(current, raw) {
+				os.Remove(l.path)
+			}
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return &LockedError{Path: l.path, Owner: owner}
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+func (l *vaultLock) release() error {
+	if l.held == 0 {
+		return nil
+	}
+	l.held--
+	if l.held != 0 {
+		return nil
+	}
+	return os.Remove(l.path)
+}
+
+// Creates the lock file holding the owner. The owner is written to a
+// temporary file first and linked into place, so other processes never see a
+// lock file that is not fully written.
+func (l *vaultLock) create() error {
+	f, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	host, _ := os.Hostname()
+	owner := lockOwner{
+		PID:      os.Getpid(),
+		Host:     host,
+		Command:  strings.Join(append([]string{filepath.Base(os.Args[0])}, os.Args[1:]...), " "),
+		Acquired: time.Now(),
+	}
+	if err := json.NewEncoder(f).Encode(owner); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// fails with os.ErrExist if the lock is held
+	err = linkLockFile(f.Name(), l.path)
+	if errors.Is(err, os.ErrPermission) || errors.Is(err, errors.ErrUnsupported) {
+		return l.createExclusive(owner) // file system without hard links
+	}
+	return err
+}
+
+// Creates the lock file holding the owner without hard links. Other
+// processes can see the file before the owner is written, see stale.
+func (l *vaultLock) createExclusive(owner lockOwner) error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(owner); err != nil {
+		f.Close()
+		os.Remove(l.path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(l.path)
+		return err
+	}
+	return nil
+}
+
+func (l *vaultLock) owner() (lockOwner, []byte, error) {
+	var owner lockOwner
+	raw, err := os.ReadFile(l.path)
+	if err != nil {
+		return owner, nil, err
+	}
+	if err := json.Unmarshal(raw, &owner); err != nil {
+		return owner, raw, nil // corrupt or still being written, see stale
+	}
+	return owner, raw, nil
+}
+
+// Reports whether the owner of the lock is gone. Owners on other hosts are
+// never considered gone as their pid cannot be checked. A lock of this
+// process is held by another client, nested Lock calls of the same client
+// are counted in held. Locks without an owner are only stale once they are
+// older than lockWriteTimeout, as they can still be written on file systems
+// without hard links.
+func (l *vaultLock) stale(owner lockOwner) bool {
+	if owner.PID == 0 {
+		info, err := os.Stat(l.path)
+		return err == nil && time.Since(info.ModTime()) > lockWriteTimeout
+	}
+	if host, _ := os.Hostname(); owner.Host != host {
+		return false
+	}
+	return owner.PID != os.Getpid() && !processAlive(owner.PID)
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package client
+
+import (
+	"os"
+)
+
+// Reports whether a process with the pid is running. Without a way to probe
+// the process it is assumed to run unless it cannot be found.
+func processAlive(pid int) bool {
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
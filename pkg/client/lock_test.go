@@ -0,0 +1,220 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestLock(t *testing.T) *vaultLock {
+	return &vaultLock{path: filepath.Join(t.TempDir(), ".soa", "lock"), timeout: 200 * time.Millisecond}
+}
+
+// Writes a lock file with the given owner.
+func writeLock(t *testing.T, l *vaultLock, owner lockOwner) {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(l.path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Returns the pid of a process that is no longer running.
+func exitedPID(t *testing.T) int {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func checkLocked(t *testing.T, err error) {
+	t.Helper()
+	var locked *LockedError
+	if !errors.Is(err, ErrVaultLocked) || !errors.As(err, &locked) {
+		t.Fatalf("got %v, want a *LockedError", err)
+	}
+}
+
+func TestLockContention(t *testing.T) {
+	first := newTestLock(t)
+	second := &vaultLock{path: first.path, timeout: first.timeout}
+
+	if err := first.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	checkLocked(t, second.acquire())
+	if waited := time.Since(start); waited < second.timeout {
+		t.Errorf("gave up after %v, before the timeout", waited)
+	}
+
+	if err := first.release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.acquire(); err != nil {
+		t.Fatalf("lock is not taken after it was released: %v", err)
+	}
+	if err := second.release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(second.path); !os.IsNotExist(err) {
+		t.Fatalf("lock file is left: %v", err)
+	}
+}
+
+// Two clients of the same vault in one process do not share the lock.
+func TestLockSameProcess(t *testing.T) {
+	dir := t.TempDir()
+	c1, err := NewBufferClient(&BufferClientConfig{soaDir: dir, zoteroDataDir: dir, lockTimeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := NewBufferClient(&BufferClientConfig{soaDir: dir, zoteroDataDir: dir, lockTimeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c1.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	checkLocked(t, c2.Lock())
+	if err := c1.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockNested(t *testing.T) {
+	l := newTestLock(t)
+	for i := 0; i < 2; i++ {
+		if err := l.acquire(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := l.release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(l.path); err != nil {
+		t.Fatalf("lock is released by the inner unlock: %v", err)
+	}
+	if err := l.release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(l.path); !os.IsNotExist(err) {
+		t.Fatalf("lock is not released by the outer unlock: %v", err)
+	}
+	if err := l.release(); err != nil {
+		t.Fatalf("releasing a lock that is not held: %v", err)
+	}
+}
+
+func TestLockStale(t *testing.T) {
+	host, _ := os.Hostname()
+	dead := exitedPID(t)
+
+	tests := []struct {
+		name  string
+		owner *lockOwner // nil writes a lock file without an owner
+		age   time.Duration
+		stale bool
+	}{
+		{name: "exited process", owner: &lockOwner{PID: dead, Host: host}, stale: true},
+		{name: "running process", owner: &lockOwner{PID: os.Getppid(), Host: host}},
+		{name: "this process", owner: &lockOwner{PID: os.Getpid(), Host: host}},
+		{name: "other host", owner: &lockOwner{PID: dead, Host: host + "-other"}},
+		{name: "being written", age: 0},
+		{name: "never written", age: 2 * lockWriteTimeout, stale: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLock(t)
+			if test.owner != nil {
+				writeLock(t, l, *test.owner)
+			} else {
+				if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(l.path, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+				modified := time.Now().Add(-test.age)
+				if err := os.Chtimes(l.path, modified, modified); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := l.acquire()
+			if !test.stale {
+				checkLocked(t, err)
+				return
+			}
+			if err != nil {
+				t.Fatalf("stale lock is not taken over: %v", err)
+			}
+			owner, _, err := l.owner()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if owner.PID != os.Getpid() {
+				t.Fatalf("lock is owned by %d", owner.PID)
+			}
+			if err := l.release(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// File systems without hard links fall back to creating the lock file
+// exclusively.
+func TestLockWithoutHardLinks(t *testing.T) {
+	defer func(link func(string, string) error) { linkLockFile = link }(linkLockFile)
+	linkLockFile = func(oldname string, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: errors.ErrUnsupported}
+	}
+
+	first := newTestLock(t)
+	second := &vaultLock{path: first.path, timeout: first.timeout}
+	if err := first.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	owner, _, err := first.owner()
+	if err != nil || owner.PID != os.Getpid() {
+		t.Fatalf("lock is owned by %d: %v", owner.PID, err)
+	}
+	checkLocked(t, second.acquire())
+
+	if err := first.release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.release(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(first.path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("files are left: %v", entries)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package client
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Reports whether a process with the pid is running.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"bytes"
+	"regexp"
+	"time"
+
+	"github.com/ubombar/soa/api"
+)
+
+const (
+	autogenBeginMarker = "<!-- soa:autogen:begin -->"
+	autogenEndMarker   = "<!-- soa:autogen:end -->"
+
+	// content of literature notes written before the autogenerated region
+	// was introduced starts with this line
+	legacyAutogenPrefix = "`this file is autogenerated`"
+)
+
+// Heading line of each annotation in the legacy autogenerated content, e.g.
+// "highlight 🟨(p.3[2]):".
+var legacyAnnotationRegexp = regexp.MustCompile(`^(highlight|underline|note) \S*\(p\.[^\n]*\[\d+\]\):\n`)
+
+// Marks the start of a single annotation inside the autogenerated region, used
+// to merge annotations by their key and modification date on re-sync.
+var annotationMarkerRegexp = regexp.MustCompile(`(?m)^<!-- soa:annotation key=(\S+) modified=(\S+) -->\n`)
+
+// Any marker line ends the annotation block before it.
+var markerRegexp = regexp.MustCompile(`(?m)^<!-- soa:`)
+
+type annotationBlock struct {
+	Modified time.Time
+	Text     []byte // rendered annotation including its marker line
+}
+
+// Returns the byte offsets of the content between the begin and end markers.
+// The offsets exclude the marker lines themselves.
+func findRegion(content []byte, begin string, end string) (int, int, bool) {
+	beginIndex := bytes.Index(content, []byte(begin+"\n"))
+	if beginIndex < 0 {
+		return 0, 0, false
+	}
+	innerStart := beginIndex + len(begin) + 1
+
+	endIndex := bytes.Index(content[innerStart:], []byte(end))
+	if endIndex < 0 {
+		return 0, 0, false
+	}
+
+	return innerStart, innerStart + endIndex, true
+}
+
+// Returns the content between the begin and end markers, nil if there is no
+// such region.
+func getRegion(content []byte, begin string, end string) []byte {
+	start, stop, ok := findRegion(content, begin, end)
+	if !ok {
+		return nil
+	}
+	return content[start:stop]
+}
+
+// Replaces the content between the begin and end markers with inner. If the
+// region does not exist it is appended to the end of the content.
+func replaceRegion(content []byte, begin string, end string, inner []byte) *bytes.Buffer {
+	b := new(bytes.Buffer)
+
+	start, stop, ok := findRegion(content, begin, end)
+	if !ok {
+		b.Write(content)
+		if b.Len() != 0 && !bytes.HasSuffix(content, []byte("\n")) {
+			b.WriteByte('\n')
+		}
+		b.WriteString(begin + "\n")
+		b.Write(inner)
+		b.WriteString(end + "\n")
+		return b
+	}
+
+	b.Write(content[:start])
+	b.Write(inner)
+	b.Write(content[stop:])
+	return b
+}
+
+// Returns the length of the legacy autogenerated part at the start of the
+// content, text the user wrote below it starts at the returned offset. Each
+// legacy annotation is its heading, the quoted text for highlights and
+// underlines, and the comment up to the next blank line.
+func legacyAutogenLength(content []byte) int {
+	if !bytes.HasPrefix(content, []byte(legacyAutogenPrefix+"\n")) {
+		return 0
+	}
+	pos := len(legacyAutogenPrefix) + 1
+	for pos < len(content) && content[pos] == '\n' {
+		pos++
+	}
+
+	for {
+		match := legacyAnnotationRegexp.FindSubmatchIndex(content[pos:])
+		if match == nil {
+			return pos
+		}
+		next := pos + match[1]
+		if string(content[pos+match[2]:pos+match[3]]) == "note" {
+			if !bytes.HasPrefix(content[next:], []byte("\n")) {
+				return pos
+			}
+			next++
+		} else {
+			if !bytes.HasPrefix(content[next:], []byte("`")) {
+				return pos
+			}
+			quoteEnd := bytes.Index(content[next+1:], []byte("`\n"))
+			if quoteEnd < 0 {
+				return pos
+			}
+			next += 1 + quoteEnd + 2
+		}
+
+		// optional comment, the annotation ends with a blank line
+		if bytes.HasPrefix(content[next:], []byte("\n")) {
+			pos = next + 1
+		} else if blank := bytes.Index(content[next:], []byte("\n\n")); blank >= 0 {
+			pos = next + blank + 2
+		} else {
+			return len(content)
+		}
+	}
+}
+
+// Splits the autogenerated region into annotation blocks keyed by the
+// annotation key.
+func parseAnnotationBlocks(region []byte) map[string]annotationBlock {
+	blocks := make(map[string]annotationBlock)
+
+	matches := annotationMarkerRegexp.FindAllSubmatchIndex(region, -1)
+	for _, match := range matches {
+		stop := len(region)
+		if next := markerRegexp.FindIndex(region[match[1]:]); next != nil {
+			stop = match[1] + next[0]
+		}
+
+		key := string(region[match[2]:match[3]])
+		modified, err := time.Parse(time.RFC3339, string(region[match[4]:match[5]]))
+		if err != nil {
+			continue // re-render annotations with broken markers
+		}
+
+		blocks[key] = annotationBlock{
+			Modified: modified,
+			Text:     region[match[0]:stop],
+		}
+	}
+
+	return blocks
+}
+
+func writeAnnotationMarker(annot api.ZoteroAnnotation, b *bytes.Buffer) {
+	b.WriteString("<!-- soa:annotation key=")
+	b.WriteString(annot.Key)
+	b.WriteString(" modified=")
+	b.WriteString(annot.DateModified.UTC().Format(time.RFC3339))
+	b.WriteString(" -->\n")
+}
+
+func writeGroupMarker(meaning api.ColorMeaning, b *bytes.Buffer) {
+	b.WriteString("<!-- soa:group -->\n")
+	b.WriteString("### ")
+	b.WriteString(meaning.Icon)
+	b.WriteString(" ")
+	b.WriteString(meaning.Meaning)
+	b.WriteString("\n\n")
+}
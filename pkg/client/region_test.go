@@ -0,0 +1,191 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ubombar/soa/api"
+	"github.com/ubombar/soa/internal/datetime"
+	"github.com/ubombar/soa/pkg/diff"
+)
+
+var (
+	readAt     = time.Date(2025, 4, 7, 18, 0, 0, 0, time.UTC)
+	modifiedAt = readAt.Add(time.Hour)
+)
+
+func testAnnotation(key string, annotType api.AnnotationType, text string, comment string, modified time.Time) api.ZoteroAnnotation {
+	return api.ZoteroAnnotation{
+		Key:                 key,
+		AnnotationType:      annotType,
+		AnnotationText:      text,
+		AnnotationComment:   comment,
+		AnnotationColor:     api.ColorYellow,
+		AnnotationPageLabel: "3",
+		AnnotationSortIndex: key,
+		AnnotationPosition:  api.ZoteroAnnotationPosition{PageIndex: 2},
+		DateModified:        datetime.DateTime{Time: modified},
+	}
+}
+
+// Rendered block of a highlight as written by the default template.
+func highlightBlock(key string, text string, comment string, modified time.Time) string {
+	block := "<!-- soa:annotation key=" + key + " modified=" + modified.Format(time.RFC3339) + " -->\n" +
+		"highlight 🟨(p.3[2]):\n" +
+		"`" + text + "`\n"
+	if comment != "" {
+		block += comment + "\n"
+	}
+	return block + "\n"
+}
+
+func region(blocks ...string) string {
+	inner := ""
+	for _, block := range blocks {
+		inner += block
+	}
+	return autogenBeginMarker + "\n" + inner + autogenEndMarker + "\n"
+}
+
+func TestGenerateLiteratureContent(t *testing.T) {
+	renderers, err := LoadAnnotationRenderers(t.TempDir(), api.DefaultColorScheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := testAnnotation("A", api.Highlight, "first", "", readAt)
+	b := testAnnotation("B", api.Highlight, "second", "why", readAt)
+
+	tests := []struct {
+		name    string
+		content string
+		annots  []api.ZoteroAnnotation
+		want    string
+	}{
+		{
+			name:    "new note",
+			content: "",
+			annots:  []api.ZoteroAnnotation{a, b},
+			want:    "\n" + region(highlightBlock("A", "first", "", readAt), highlightBlock("B", "second", "why", readAt)),
+		},
+		{
+			name: "legacy note without markers",
+			content: legacyAutogenPrefix + "\n" +
+				"\n" +
+				"highlight 🟨(p.3[2]):\n" +
+				"`first`\n" +
+				"\n" +
+				"note 🟨(p.1[0]):\n" +
+				"\n" +
+				"sticky\n" +
+				"\n" +
+				"highlight 🟨(p.4[3]):\n" +
+				"`text with `backticks` inside`\n" +
+				"a comment\n" +
+				"over two lines\n" +
+				"\n" +
+				"My own thoughts.\n" +
+				"\n" +
+				"More of them.\n",
+			annots: []api.ZoteroAnnotation{a},
+			want: "\n" + region(highlightBlock("A", "first", "", readAt)) +
+				"\n" +
+				"My own thoughts.\n" +
+				"\n" +
+				"More of them.\n",
+		},
+		{
+			name:    "legacy note without user text",
+			content: legacyAutogenPrefix + "\n\nhighlight 🟨(p.3[2]):\n`first`\n\n",
+			annots:  []api.ZoteroAnnotation{a},
+			want:    "\n" + region(highlightBlock("A", "first", "", readAt)),
+		},
+		{
+			name:    "user text around the region",
+			content: "# Paper\n\nBefore.\n\n" + region(highlightBlock("A", "first", "", readAt)) + "\nAfter.\n",
+			annots:  []api.ZoteroAnnotation{a, b},
+			want:    "# Paper\n\nBefore.\n\n" + region(highlightBlock("A", "first", "", readAt), highlightBlock("B", "second", "why", readAt)) + "\nAfter.\n",
+		},
+		{
+			name:    "annotation edited in zotero",
+			content: "Before.\n" + region(highlightBlock("A", "first", "", readAt), highlightBlock("B", "second", "why", readAt)) + "After.\n",
+			annots:  []api.ZoteroAnnotation{a, testAnnotation("B", api.Highlight, "second", "changed", modifiedAt)},
+			want:    "Before.\n" + region(highlightBlock("A", "first", "", readAt), highlightBlock("B", "second", "changed", modifiedAt)) + "After.\n",
+		},
+		{
+			name:    "annotation edited in the note",
+			content: region(highlightBlock("A", "first", "my words", readAt)),
+			annots:  []api.ZoteroAnnotation{a},
+			want:    region(highlightBlock("A", "first", "my words", readAt)),
+		},
+		{
+			name:    "annotation removed in zotero",
+			content: "Before.\n" + region(highlightBlock("A", "first", "", readAt), highlightBlock("B", "second", "why", readAt)) + "After.\n",
+			annots:  []api.ZoteroAnnotation{b},
+			want:    "Before.\n" + region(highlightBlock("B", "second", "why", readAt)) + "After.\n",
+		},
+		{
+			name:    "region appended to a note without one",
+			content: "Written by hand",
+			annots:  []api.ZoteroAnnotation{a},
+			want:    "Written by hand\n" + region(highlightBlock("A", "first", "", readAt)),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attachements := []api.ZoteroAttachementItem{{Annotations: test.annots}}
+			got, err := generateLiteratureContent(bytes.NewBufferString(test.content), attachements, renderers, api.DefaultColorScheme, LiteratureGroupByNone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != test.want {
+				t.Errorf("unexpected content\n%s", diff.Unified("want", "got", test.want, got.String()))
+			}
+		})
+	}
+}
+
+func TestLegacyAutogenLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    int
+	}{
+		{"not legacy", "# Note\n\nhighlight 🟨(p.3[2]):\n`text`\n\n", 0},
+		{"only the prefix", legacyAutogenPrefix + "\n", len(legacyAutogenPrefix) + 1},
+		{"stops at user text", legacyAutogenPrefix + "\n\nhighlight 🟨(p.3[2]):\n`text`\n\nmine\n", len(legacyAutogenPrefix + "\n\nhighlight 🟨(p.3[2]):\n`text`\n\n")},
+		{"unclosed quote", legacyAutogenPrefix + "\n\nhighlight 🟨(p.3[2]):\n`text\n", len(legacyAutogenPrefix) + 2},
+		{"comment up to the end", legacyAutogenPrefix + "\n\nunderline 🟨(p.3[2]):\n`text`\ncomment", len(legacyAutogenPrefix + "\n\nunderline 🟨(p.3[2]):\n`text`\ncomment")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := legacyAutogenLength([]byte(test.content)); got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseAnnotationBlocks(t *testing.T) {
+	a := highlightBlock("A", "first", "", readAt)
+	b := highlightBlock("B", "second", "why", modifiedAt)
+	broken := "<!-- soa:annotation key=C modified=yesterday -->\nhighlight\n\n"
+	blocks := parseAnnotationBlocks([]byte(a + broken + b))
+
+	if len(blocks) != 2 {
+		t.Fatalf("got %d blocks, want 2", len(blocks))
+	}
+	want := map[string]annotationBlock{
+		"A": {Modified: readAt, Text: []byte(a)},
+		"B": {Modified: modifiedAt, Text: []byte(b)},
+	}
+	for key, block := range want {
+		got, ok := blocks[key]
+		if !ok {
+			t.Fatalf("block %s is not found", key)
+		}
+		if !got.Modified.Equal(block.Modified) || string(got.Text) != string(block.Text) {
+			t.Errorf("block %s: got %s %q, want %s %q", key, got.Modified, got.Text, block.Modified, block.Text)
+		}
+	}
+}
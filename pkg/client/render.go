@@ -0,0 +1,129 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/ubombar/soa/api"
+)
+
+// Renders a single annotation into the autogenerated region of a literature
+// note.
+type AnnotationRenderer interface {
+	Render(w io.Writer, annot api.ZoteroAnnotation) error
+}
+
+// Implemented by renderers that copy a file rendered by zotero into the
+// vault, such as the image of an annotation. Annotations whose file is not in
+// the vault yet are rendered again on every sync.
+type exportingRenderer interface {
+	Exported(annot api.ZoteroAnnotation) bool
+}
+
+// Renderers selected by the annotation type, annotations without a renderer
+// are not written to the note.
+type AnnotationRenderers map[api.AnnotationType]AnnotationRenderer
+
+// Data given to the annotation templates, the annotation fields can be used
+// directly such as {{.AnnotationText}}.
+type AnnotationData struct {
+	api.ZoteroAnnotation
+	Image string // path of the exported image relative to the note, only for image and ink annotations
+}
+
+// Renders the annotation with a text/template, AnnotationData is given as the
+// template data.
+type TemplateRenderer struct {
+	tmpl *template.Template
+}
+
+func NewTemplateRenderer(name string, text string, scheme api.ColorScheme) (*TemplateRenderer, error) {
+	tmpl, err := template.New(name).Funcs(annotationTemplateFuncs(scheme)).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateRenderer{tmpl: tmpl}, nil
+}
+
+func (r *TemplateRenderer) Render(w io.Writer, annot api.ZoteroAnnotation) error {
+	return r.Execute(w, AnnotationData{ZoteroAnnotation: annot})
+}
+
+func (r *TemplateRenderer) Execute(w io.Writer, data AnnotationData) error {
+	return r.tmpl.Execute(w, data)
+}
+
+func annotationTemplateFuncs(scheme api.ColorScheme) template.FuncMap {
+	return template.FuncMap{
+		"icon": func(color api.AnnotationColor) string {
+			return scheme.Get(color).Icon
+		},
+		"colorName": func(color api.AnnotationColor) string {
+			return scheme.Get(color).Name
+		},
+		"meaning": func(color api.AnnotationColor) string {
+			return scheme.Get(color).Meaning
+		},
+		"quote": func(text string) string {
+			return "> " + strings.ReplaceAll(text, "\n", "\n> ")
+		},
+	}
+}
+
+// Default templates of the annotation types, can be overridden by placing a
+// <type>.tmpl file under the templates folder of the vault.
+var DefaultAnnotationTemplates = map[api.AnnotationType]string{
+	api.Highlight: "highlight {{icon .AnnotationColor}}(p.{{.AnnotationPageLabel}}[{{.AnnotationPosition.PageIndex}}]):\n" +
+		"`{{.AnnotationText}}`\n" +
+		"{{with .AnnotationComment}}{{.}}\n{{end}}\n",
+	api.Note: "note {{icon .AnnotationColor}}(p.{{.AnnotationPageLabel}}[{{.AnnotationPosition.PageIndex}}]):\n\n" +
+		"{{with .AnnotationComment}}{{.}}\n{{end}}\n",
+	api.Underline: "underline {{icon .AnnotationColor}}(p.{{.AnnotationPageLabel}}[{{.AnnotationPosition.PageIndex}}]):\n" +
+		"`{{.AnnotationText}}`\n" +
+		"{{with .AnnotationComment}}{{.}}\n{{end}}\n",
+	api.Text: "text {{icon .AnnotationColor}}(p.{{.AnnotationPageLabel}}[{{.AnnotationPosition.PageIndex}}]):\n" +
+		"{{quote (or .AnnotationText .AnnotationComment)}}\n\n",
+	api.Image: "image {{icon .AnnotationColor}}(p.{{.AnnotationPageLabel}}[{{.AnnotationPosition.PageIndex}}]):\n" +
+		"{{with .Image}}![p.{{$.AnnotationPageLabel}}](<{{.}}>)\n{{end}}" +
+		"{{with .AnnotationComment}}{{.}}\n{{end}}\n",
+	api.Ink: "ink {{icon .AnnotationColor}}(p.{{.AnnotationPageLabel}}[{{.AnnotationPosition.PageIndex}}]):\n" +
+		"{{with .Image}}![p.{{$.AnnotationPageLabel}}](<{{.}}>)\n{{end}}" +
+		"{{with .AnnotationComment}}{{.}}\n{{end}}\n",
+}
+
+var annotationTypes = []api.AnnotationType{api.Ink, api.Note, api.Highlight, api.Text, api.Image, api.Underline}
+
+// Loads the default renderers and overrides them with the templates found in
+// the given folder. The templates are named after the annotation type such as
+// highlight.tmpl.
+func LoadAnnotationRenderers(templatesDir string, scheme api.ColorScheme) (AnnotationRenderers, error) {
+	renderers := AnnotationRenderers{}
+
+	for _, annotType := range annotationTypes {
+		name := string(annotType) + ".tmpl"
+		text, ok := DefaultAnnotationTemplates[annotType]
+
+		userText, err := os.ReadFile(filepath.Join(templatesDir, name))
+		if err == nil {
+			text, ok = string(userText), true
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		renderer, err := NewTemplateRenderer(name, text, scheme)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse template %s: %w", name, err)
+		}
+		renderers[annotType] = renderer
+	}
+
+	return renderers, nil
+}
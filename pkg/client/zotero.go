@@ -3,8 +3,12 @@ package client
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"strings"
 
 	"github.com/ubombar/soa/api"
 )
@@ -13,7 +17,55 @@ type ZoteroClientConfig struct {
 	Enpoint *url.URL
 }
 
-const DefaultZoteroClientEndpoint = "http://localhost:23119/better-bibtex/"
+const (
+	DefaultZoteroClientEndpoint = "http://localhost:23119/better-bibtex/"
+	betterBibTexJSONTranslator  = "BetterBibTeX JSON"
+)
+
+var (
+	ErrCitationKeyNotFound      = errors.New("citation key not found in zotero")
+	ErrUnexpectedExportResult   = errors.New("unexpected item.export result")
+	ErrUnknownAttachementPolicy = errors.New("unknown attachement policy")
+)
+
+// Decides which attachements of an item are used for its literature note.
+type AttachementPolicy string
+
+const (
+	AttachementPolicyFirst AttachementPolicy = "first" // first pdf attachement, metadata only if there is none
+	AttachementPolicyMerge AttachementPolicy = "merge" // annotations of every attachement
+	AttachementPolicyNone  AttachementPolicy = "none"  // metadata only, no annotations
+)
+
+func ParseAttachementPolicy(s string) (AttachementPolicy, error) {
+	switch policy := AttachementPolicy(s); policy {
+	case AttachementPolicyFirst, AttachementPolicyMerge, AttachementPolicyNone:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownAttachementPolicy, s)
+	}
+}
+
+// Returns the attachements to use according to the policy.
+func SelectAttachements(policy AttachementPolicy, attachements []api.ZoteroAttachementItem) []api.ZoteroAttachementItem {
+	switch policy {
+	case AttachementPolicyFirst:
+		for _, attachement := range attachements {
+			if isPDF(attachement.Path) {
+				return []api.ZoteroAttachementItem{attachement}
+			}
+		}
+		return nil
+	case AttachementPolicyMerge:
+		return attachements
+	default:
+		return nil
+	}
+}
+
+func isPDF(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".pdf")
+}
 
 // This client uses the Zotero's Bette rBibtext plugin, ensure it is installed
 // to reach the endpoint
@@ -72,22 +124,95 @@ func (c *ZoteroClient) SelectBibTextEntries() ([]api.ZoteroCitationEntry, error)
 
 // Gets the attachement from a citationKey
 func (c *ZoteroClient) GetAttachements(citationKey string) ([]api.ZoteroAttachementItem, error) {
+	var attachements []api.ZoteroAttachementItem
+	if err := c.call("item.attachments", []string{citationKey}, &attachements); err != nil {
+		return nil, err
+	}
+	return attachements, nil
+}
+
+// Gets the citation entries of the given citation keys without invoking the
+// selection UI. The entries are returned in the same order as the keys.
+func (c *ZoteroClient) GetCitationEntries(citationKeys []string) ([]api.ZoteroCitationEntry, error) {
+	var raw json.RawMessage
+	if err := c.call("item.export", []any{citationKeys, betterBibTexJSONTranslator}, &raw); err != nil {
+		return nil, err
+	}
+
+	exported, err := decodeExportResult(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var export api.ZoteroExport
+	if err := json.Unmarshal([]byte(exported), &export); err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]api.ZoteroItemDetails, len(export.Items))
+	for _, item := range export.Items {
+		items[item.CitationKey] = item
+	}
+
+	entries := make([]api.ZoteroCitationEntry, 0, len(citationKeys))
+	for _, citationKey := range citationKeys {
+		item, ok := items[citationKey]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrCitationKeyNotFound, citationKey)
+		}
+		entries = append(entries, api.ZoteroCitationEntry{
+			ID:          item.ItemID,
+			CitationKey: item.CitationKey,
+			ItemType:    item.ItemType,
+			Title:       item.Title,
+			Item:        item,
+		})
+	}
+
+	return entries, nil
+}
+
+// Older versions of Better BibTeX return [status, contentType, body] from
+// item.export, newer ones return only the body.
+func decodeExportResult(raw json.RawMessage) (string, error) {
+	var body string
+	if err := json.Unmarshal(raw, &body); err == nil {
+		return body, nil
+	}
+
+	var triple []any
+	if err := json.Unmarshal(raw, &triple); err != nil {
+		return "", err
+	}
+	if len(triple) != 3 {
+		return "", ErrUnexpectedExportResult
+	}
+	body, ok := triple[2].(string)
+	if !ok {
+		return "", ErrUnexpectedExportResult
+	}
+	return body, nil
+}
+
+// Calls the given method on the json-rpc endpoint and decodes the result into
+// the given pointer.
+func (c *ZoteroClient) call(method string, params any, result any) error {
 	payload := map[string]interface{}{
 		"jsonrpc": "2.0",
-		"method":  "item.attachments",
-		"params":  []string{citationKey},
+		"method":  method,
+		"params":  params,
 	}
 
 	data, err := json.Marshal(payload)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	enpointURL := c.cfg.Enpoint.ResolveReference(&url.URL{Path: "json-rpc"})
 
 	req, err := http.NewRequest("POST", enpointURL.String(), bytes.NewBuffer(data))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -95,14 +220,17 @@ func (c *ZoteroClient) GetAttachements(citationKey string) ([]api.ZoteroAttachem
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
-	var attachementResponse api.ZoteroAttachementResponse
-	if err := json.NewDecoder(resp.Body).Decode(&attachementResponse); err != nil {
-		return nil, err
+	var rpcResponse api.ZoteroRPCResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResponse); err != nil {
+		return err
+	}
+	if rpcResponse.Error != nil {
+		return rpcResponse.Error
 	}
 
-	return attachementResponse.Result, nil
+	return json.Unmarshal(rpcResponse.Result, result)
 }
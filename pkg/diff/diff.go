@@ -0,0 +1,359 @@
+package diff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Kind of a line in the diff.
+type OpKind int
+
+const (
+	Equal  OpKind = iota // line is in both
+	Delete               // line is only in the old lines
+	Insert               // line is only in the new lines
+)
+
+// Single line of the diff, the line numbers start from 0 and are -1 when the
+// line is not on that side.
+type Op struct {
+	Kind    OpKind
+	OldLine int
+	NewLine int
+	Text    string
+}
+
+// Splits the text into lines, the line endings are kept so that a missing
+// final newline shows up in the diff.
+func SplitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if len(lines) != 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Returns the operations turning the old lines into the new ones. The edit
+// script is the shortest one, found with the linear space variant of Myers'
+// algorithm so that large files with many changes stay cheap to diff.
+func Lines(old []string, new []string) []Op {
+	d := &differ{
+		a:   old,
+		b:   new,
+		ops: make([]Op, 0, max(len(old), len(new))),
+	}
+	size := 2*(len(old)+len(new)) + 4
+	d.forward = make([]int, size)
+	d.backward = make([]int, size)
+	d.compare(0, len(old), 0, len(new))
+	return d.ops
+}
+
+// State of a diff, the furthest reaching paths of the middle snake search are
+// kept between the calls to avoid allocating them again.
+type differ struct {
+	a, b              []string
+	ops               []Op
+	forward, backward []int // x of the furthest reaching path on each diagonal
+}
+
+// Appends the operations turning a[aStart:aEnd] into b[bStart:bEnd].
+func (d *differ) compare(aStart int, aEnd int, bStart int, bEnd int) {
+	// common prefix and suffix are cheap to match and keep the search small
+	for aStart < aEnd && bStart < bEnd && d.a[aStart] == d.b[bStart] {
+		d.ops = append(d.ops, Op{Kind: Equal, OldLine: aStart, NewLine: bStart, Text: d.a[aStart]})
+		aStart++
+		bStart++
+	}
+	suffix := 0
+	for aStart < aEnd-suffix && bStart < bEnd-suffix && d.a[aEnd-1-suffix] == d.b[bEnd-1-suffix] {
+		suffix++
+	}
+	aEnd -= suffix
+	bEnd -= suffix
+
+	switch {
+	case aStart == aEnd:
+		for j := bStart; j < bEnd; j++ {
+			d.ops = append(d.ops, Op{Kind: Insert, OldLine: -1, NewLine: j, Text: d.b[j]})
+		}
+	case bStart == bEnd:
+		for i := aStart; i < aEnd; i++ {
+			d.ops = append(d.ops, Op{Kind: Delete, OldLine: i, NewLine: -1, Text: d.a[i]})
+		}
+	default:
+		x, y, u, v := d.middleSnake(aStart, aEnd, bStart, bEnd)
+		d.compare(aStart, x, bStart, y)
+		for ; x < u; x, y = x+1, y+1 {
+			d.ops = append(d.ops, Op{Kind: Equal, OldLine: x, NewLine: y, Text: d.a[x]})
+		}
+		d.compare(u, aEnd, v, bEnd)
+	}
+
+	for k := 0; k < suffix; k++ {
+		d.ops = append(d.ops, Op{Kind: Equal, OldLine: aEnd + k, NewLine: bEnd + k, Text: d.a[aEnd+k]})
+	}
+}
+
+// Returns the middle snake of the shortest edit script of the ranges, the
+// diagonal from (x, y) to (u, v) in the old and new line numbers. Paths are
+// searched from both ends at once until they overlap. The ranges must not be
+// empty.
+func (d *differ) middleSnake(aStart int, aEnd int, bStart int, bEnd int) (int, int, int, int) {
+	n, m := aEnd-aStart, bEnd-bStart
+	delta := n - m
+	odd := delta%2 != 0
+	// diagonal k is x - y, the paths are stored relative to the start of the
+	// ranges and the backward ones count from the end
+	offset := len(d.forward) / 2
+	forward, backward := d.forward, d.backward
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	for step := 0; step <= (n+m+1)/2; step++ {
+		for k := -step; k <= step; k += 2 {
+			var x int
+			if k == -step || (k != step && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1] // insert
+			} else {
+				x = forward[offset+k-1] + 1 // delete
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && d.a[aStart+x] == d.b[bStart+y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			if odd && delta-k >= -(step-1) && delta-k <= step-1 && x+backward[offset+delta-k] >= n {
+				return aStart + x0, bStart + y0, aStart + x, bStart + y
+			}
+		}
+
+		for k := -step; k <= step; k += 2 {
+			var x int
+			if k == -step || (k != step && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && d.a[aEnd-1-x] == d.b[bEnd-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			if !odd && delta-k >= -step && delta-k <= step && x+forward[offset+delta-k] >= n {
+				return aEnd - x, bEnd - y, aEnd - x0, bEnd - y0
+			}
+		}
+	}
+	panic("diff: no middle snake") // the paths always overlap by then
+}
+
+// Number of unchanged lines shown around the changes.
+const contextLines = 3
+
+// Returns the unified diff of the texts, empty if they are equal.
+func Unified(oldName string, newName string, old string, new string) string {
+	ops := Lines(SplitLines(old), SplitLines(new))
+
+	var b strings.Builder
+	for start := 0; start < len(ops); {
+		// find the next change and the end of its hunk
+		first := start
+		for first < len(ops) && ops[first].Kind == Equal {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		for k := first; k < len(ops); k++ {
+			if ops[k].Kind != Equal {
+				last = k
+			} else if k-last > 2*contextLines {
+				break
+			}
+		}
+		hunk := ops[max(start, first-contextLines):min(len(ops), last+contextLines+1)]
+		start = last + contextLines + 1
+
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		writeHunk(&b, hunk)
+	}
+	return b.String()
+}
+
+func writeHunk(b *strings.Builder, hunk []Op) {
+	oldStart, newStart := -1, -1
+	oldCount, newCount := 0, 0
+	for _, op := range hunk {
+		if op.Kind != Insert {
+			if oldStart < 0 {
+				oldStart = op.OldLine
+			}
+			oldCount++
+		}
+		if op.Kind != Delete {
+			if newStart < 0 {
+				newStart = op.NewLine
+			}
+			newCount++
+		}
+	}
+	fmt.Fprintf(b, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+
+	for _, op := range hunk {
+		switch op.Kind {
+		case Equal:
+			b.WriteString(" ")
+		case Delete:
+			b.WriteString("-")
+		case Insert:
+			b.WriteString("+")
+		}
+		b.WriteString(op.Text)
+		if !strings.HasSuffix(op.Text, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// Returns the range of the hunk on one side in start,count form. Hunks
+// always have context lines unless that side is empty.
+func hunkRange(start int, count int) string {
+	switch count {
+	case 0:
+		return "0,0"
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+// Change of one side of a merge, the base lines [start, end) are replaced by
+// the lines.
+type hunk struct {
+	start, end int
+	lines      []string
+}
+
+// Returns the changes of the operations relative to the old lines.
+func hunks(ops []Op) []hunk {
+	result := []hunk{}
+	base := 0
+	var current *hunk
+	for _, op := range ops {
+		if op.Kind == Equal {
+			if current != nil {
+				result = append(result, *current)
+				current = nil
+			}
+			base++
+			continue
+		}
+		if current == nil {
+			current = &hunk{start: base, end: base}
+		}
+		if op.Kind == Delete {
+			base++
+			current.end = base
+		} else {
+			current.lines = append(current.lines, op.Text)
+		}
+	}
+	if current != nil {
+		result = append(result, *current)
+	}
+	return result
+}
+
+// Returns the base lines [start, end) with the changes applied, the changes
+// have to be within the range.
+func apply(base []string, start int, end int, changes []hunk) []string {
+	out := []string{}
+	position := start
+	for _, h := range changes {
+		out = append(out, base[position:h.start]...)
+		out = append(out, h.lines...)
+		position = h.end
+	}
+	return append(out, base[position:end]...)
+}
+
+// Three-way merges the changes of ours and theirs to the common base. Changes
+// to different lines are combined, identical changes are taken once. If both
+// sides change the same or adjacent lines differently the result has
+// conflict markers and false is returned.
+func Merge(base []string, ours []string, theirs []string) ([]string, bool) {
+	a := hunks(Lines(base, ours))
+	b := hunks(Lines(base, theirs))
+
+	merged := []string{}
+	clean := true
+	position := 0
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		// start a group from the earliest change and add the overlapping ones
+		var start, end int
+		if j == len(b) || (i < len(a) && a[i].start <= b[j].start) {
+			start, end = a[i].start, a[i].end
+		} else {
+			start, end = b[j].start, b[j].end
+		}
+		groupA, groupB := []hunk{}, []hunk{}
+		for {
+			if i < len(a) && a[i].start <= end {
+				groupA = append(groupA, a[i])
+				end = max(end, a[i].end)
+				i++
+			} else if j < len(b) && b[j].start <= end {
+				groupB = append(groupB, b[j])
+				end = max(end, b[j].end)
+				j++
+			} else {
+				break
+			}
+		}
+
+		merged = append(merged, base[position:start]...)
+		position = end
+
+		oursLines := apply(base, start, end, groupA)
+		theirsLines := apply(base, start, end, groupB)
+		switch {
+		case len(groupB) == 0:
+			merged = append(merged, oursLines...)
+		case len(groupA) == 0:
+			merged = append(merged, theirsLines...)
+		case slices.Equal(oursLines, theirsLines):
+			merged = append(merged, oursLines...)
+		default:
+			clean = false
+			merged = append(merged, "<<<<<<< ours\n")
+			merged = append(merged, terminated(oursLines)...)
+			merged = append(merged, "=======\n")
+			merged = append(merged, terminated(theirsLines)...)
+			merged = append(merged, ">>>>>>> theirs\n")
+		}
+	}
+	merged = append(merged, base[position:]...)
+
+	return merged, clean
+}
+
+// Returns the lines with a newline added to the last one if it is missing,
+// so that a marker can follow it.
+func terminated(lines []string) []string {
+	if len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lines
+	}
+	out := slices.Clone(lines)
+	out[len(out)-1] += "\n"
+	return out
+}
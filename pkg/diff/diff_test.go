@@ -0,0 +1,260 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Returns the length of the longest common subsequence of the lines.
+func lcsLength(a []string, b []string) int {
+	row := make([]int, len(b)+1)
+	for i := len(a) - 1; i >= 0; i-- {
+		diagonal := 0
+		for j := len(b) - 1; j >= 0; j-- {
+			previous := row[j]
+			if a[i] == b[j] {
+				row[j] = diagonal + 1
+			} else {
+				row[j] = max(row[j], row[j+1])
+			}
+			diagonal = previous
+		}
+	}
+	return row[0]
+}
+
+// Checks that the operations turn old into new with the fewest changes.
+func checkLines(t *testing.T, old []string, new []string) {
+	t.Helper()
+	ops := Lines(old, new)
+
+	var gotOld, gotNew []string
+	equal := 0
+	for _, op := range ops {
+		switch op.Kind {
+		case Equal:
+			if old[op.OldLine] != op.Text || new[op.NewLine] != op.Text {
+				t.Fatalf("equal line %+v does not match", op)
+			}
+			gotOld = append(gotOld, op.Text)
+			gotNew = append(gotNew, op.Text)
+			equal++
+		case Delete:
+			if old[op.OldLine] != op.Text || op.NewLine != -1 {
+				t.Fatalf("deleted line %+v does not match", op)
+			}
+			gotOld = append(gotOld, op.Text)
+		case Insert:
+			if new[op.NewLine] != op.Text || op.OldLine != -1 {
+				t.Fatalf("inserted line %+v does not match", op)
+			}
+			gotNew = append(gotNew, op.Text)
+		}
+	}
+	if !slices.Equal(gotOld, old) || !slices.Equal(gotNew, new) {
+		t.Fatalf("operations of %q -> %q do not rebuild the lines: %+v", old, new, ops)
+	}
+	if want := lcsLength(old, new); equal != want {
+		t.Fatalf("%q -> %q keeps %d lines, the longest common subsequence has %d", old, new, equal, want)
+	}
+}
+
+func randomLines(r *rand.Rand, n int, alphabet int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%d\n", r.Intn(alphabet))
+	}
+	return lines
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		old, new string
+	}{
+		{"", ""},
+		{"", "a\n"},
+		{"a\n", ""},
+		{"a\nb\nc\n", "a\nb\nc\n"},
+		{"a\nb\nc\n", "a\nx\nc\n"},
+		{"a\nb\nc\n", "x\nb\ny\n"},
+		{"a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n"},
+		{"a\nb", "a\nb\n"},
+	}
+	for _, test := range tests {
+		checkLines(t, SplitLines(test.old), SplitLines(test.new))
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		alphabet := 2 + r.Intn(10)
+		checkLines(t, randomLines(r, r.Intn(40), alphabet), randomLines(r, r.Intn(40), alphabet))
+	}
+}
+
+// Files rewritten almost entirely are diffed without a table of all line
+// pairs.
+func TestLinesLarge(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	old := randomLines(r, 20000, 1000000)
+	new := slices.Clone(old)
+	for i := 0; i < len(new); i += 10 {
+		new[i] = "changed\n"
+	}
+
+	ops := Lines(old, new)
+	changed := 0
+	for _, op := range ops {
+		if op.Kind != Equal {
+			changed++
+		}
+	}
+	if changed != 4000 {
+		t.Fatalf("got %d changed lines, want 4000", changed)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		want               string
+		clean              bool
+	}{
+		{
+			name:   "no changes",
+			base:   "a\nb\nc\n",
+			ours:   "a\nb\nc\n",
+			theirs: "a\nb\nc\n",
+			want:   "a\nb\nc\n",
+			clean:  true,
+		},
+		{
+			name:   "only ours",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nb\nc\n",
+			want:   "a\nB\nc\n",
+			clean:  true,
+		},
+		{
+			name:   "only theirs",
+			base:   "a\nb\nc\n",
+			ours:   "a\nb\nc\n",
+			theirs: "a\nb\nc\nd\n",
+			want:   "a\nb\nc\nd\n",
+			clean:  true,
+		},
+		{
+			name:   "non-overlapping edits",
+			base:   "a\nb\nc\nd\ne\n",
+			ours:   "a\nB\nc\nd\ne\n",
+			theirs: "a\nb\nc\nD\ne\n",
+			want:   "a\nB\nc\nD\ne\n",
+			clean:  true,
+		},
+		{
+			name:   "insert and delete",
+			base:   "a\nb\nc\nd\ne\n",
+			ours:   "a\nb\nnew\nc\nd\ne\n",
+			theirs: "a\nb\nc\ne\n",
+			want:   "a\nb\nnew\nc\ne\n",
+			clean:  true,
+		},
+		{
+			name:   "same edit on both sides",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nB\nc\n",
+			want:   "a\nB\nc\n",
+			clean:  true,
+		},
+		{
+			name:   "edits at the start and the end",
+			base:   "a\nb\nc\nd\n",
+			ours:   "start\na\nb\nc\nd\n",
+			theirs: "a\nb\nc\nd\nend\n",
+			want:   "start\na\nb\nc\nd\nend\n",
+			clean:  true,
+		},
+		{
+			name:   "first and last line changed",
+			base:   "a\nb\nc\n",
+			ours:   "A\nb\nc\n",
+			theirs: "a\nb\nC\n",
+			want:   "A\nb\nC\n",
+			clean:  true,
+		},
+		{
+			name:   "overlapping edits",
+			base:   "a\nb\nc\n",
+			ours:   "a\nours\nc\n",
+			theirs: "a\ntheirs\nc\n",
+			want:   "a\n<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\nc\n",
+		},
+		{
+			name:   "adjacent edits",
+			base:   "a\nb\nc\nd\n",
+			ours:   "a\nB\nc\nd\n",
+			theirs: "a\nb\nC\nd\n",
+			want:   "a\n<<<<<<< ours\nB\nc\n=======\nb\nC\n>>>>>>> theirs\nd\n",
+		},
+		{
+			name:   "edit and delete of the same line",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nc\n",
+			want:   "a\n<<<<<<< ours\nB\n=======\n>>>>>>> theirs\nc\n",
+		},
+		{
+			name:   "different inserts at the start",
+			base:   "a\n",
+			ours:   "ours\na\n",
+			theirs: "theirs\na\n",
+			want:   "<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\na\n",
+		},
+		{
+			name:   "different appends to an empty file",
+			base:   "",
+			ours:   "ours\n",
+			theirs: "theirs\n",
+			want:   "<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\n",
+		},
+		{
+			name:   "no final newline kept",
+			base:   "a\nb\nc",
+			ours:   "A\nb\nc",
+			theirs: "a\nb\nC",
+			want:   "A\nb\nC",
+			clean:  true,
+		},
+		{
+			name:   "final newline added on one side",
+			base:   "a\nb\nc",
+			ours:   "A\nb\nc",
+			theirs: "a\nb\nc\n",
+			want:   "A\nb\nc\n",
+			clean:  true,
+		},
+		{
+			name:   "conflict without final newline",
+			base:   "a\nb",
+			ours:   "a\nours",
+			theirs: "a\ntheirs",
+			want:   "a\n<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, clean := Merge(SplitLines(test.base), SplitLines(test.ours), SplitLines(test.theirs))
+			if got := strings.Join(merged, ""); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			if clean != test.clean {
+				t.Errorf("got clean %v, want %v", clean, test.clean)
+			}
+		})
+	}
+}
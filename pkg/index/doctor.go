@@ -0,0 +1,113 @@
+package index
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/ubombar/soa/internal/util"
+	"github.com/ubombar/soa/pkg/client"
+)
+
+// Category of the problems found in the vault.
+type Check string
+
+const (
+	CheckMalformedHeader Check = "malformed-header" // note cannot be parsed
+	CheckUnknownKind     Check = "unknown-kind"     // note without a kind header
+	CheckBrokenLink      Check = "broken-link"      // link to a note or file that does not exist
+	CheckMissingFrom     Check = "missing-from"     // from header pointing to a note that does not exist
+	CheckMissingPDF      Check = "missing-pdf"      // pdf header pointing to a file that does not exist
+	CheckOrphan          Check = "orphan"           // note without any inbound links
+)
+
+var AllChecks = []Check{CheckMalformedHeader, CheckUnknownKind, CheckBrokenLink, CheckMissingFrom, CheckMissingPDF, CheckOrphan}
+
+// Problem found in a note.
+type Problem struct {
+	Check   Check
+	Note    *Note
+	Line    int // line in the content, 0 if the problem is in the header
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s: %s", p.Note.Path, p.Check, p.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", p.Note.Path, p.Line, p.Check, p.Message)
+}
+
+// Runs the given checks over every note of the vault, the problems are sorted
+// by the note path.
+func (idx *Index) Diagnose(checks []Check) []Problem {
+	enabled := map[Check]bool{}
+	for _, check := range checks {
+		enabled[check] = true
+	}
+
+	problems := []Problem{}
+	add := func(check Check, note *Note, line int, format string, args ...any) {
+		if enabled[check] {
+			problems = append(problems, Problem{Check: check, Note: note, Line: line, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	inbound := map[*Note]bool{}
+	for _, note := range idx.All() {
+		if note.Error != "" {
+			add(CheckMalformedHeader, note, 0, "%s", note.Error)
+			continue
+		}
+		if note.Kind == "" || note.Kind == client.UnknownKind {
+			add(CheckUnknownKind, note, 0, "kind is %q", client.UnknownKind)
+		}
+
+		if pdf := note.HeaderString("pdf"); note.Kind == "literature" && pdf != "" && !idx.fileExists(note, pdf) {
+			add(CheckMissingPDF, note, 0, "pdf %q does not exist", pdf)
+		}
+
+		for _, link := range idx.Outlinks(note) {
+			if link.Target != nil {
+				inbound[link.Target] = inbound[link.Target] || link.Target != note
+				continue
+			}
+			switch {
+			case link.Link.Type == client.HeaderLink && link.Link.Key == "from":
+				add(CheckMissingFrom, note, 0, "from %q does not exist", link.Link.Target)
+			case link.Link.Type == client.HeaderLink:
+				add(CheckBrokenLink, note, 0, "%s %q does not exist", link.Link.Key, link.Link.Target)
+			case link.Link.IsNote():
+				add(CheckBrokenLink, note, link.Link.Line, "note %q does not exist", link.Link.Target)
+			case link.Link.Type == client.MarkdownLink && !idx.fileExists(note, link.Link.Target):
+				add(CheckBrokenLink, note, link.Link.Line, "file %q does not exist", link.Link.Target)
+			}
+		}
+	}
+
+	for _, note := range idx.All() {
+		if note.Error == "" && !inbound[note] {
+			add(CheckOrphan, note, 0, "no other note links to it")
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Note.Path < problems[j].Note.Path
+	})
+	return problems
+}
+
+// Reports whether the file exists, relative paths are tried against the
+// folder of the note and the vault.
+func (idx *Index) fileExists(note *Note, name string) bool {
+	name, err := util.ExpandHome(name)
+	if err != nil {
+		return false
+	}
+	if filepath.IsAbs(name) {
+		return util.FileExists(name)
+	}
+	return util.FileExists(filepath.Join(idx.vaultDir, filepath.FromSlash(path.Dir(note.Path)), name)) ||
+		util.FileExists(filepath.Join(idx.vaultDir, name))
+}
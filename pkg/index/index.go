@@ -0,0 +1,278 @@
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ubombar/soa/internal/config"
+	"github.com/ubombar/soa/internal/datetime"
+	"github.com/ubombar/soa/internal/log"
+	"github.com/ubombar/soa/internal/util"
+	"github.com/ubombar/soa/pkg/client"
+)
+
+// Bump this when the cached note format changes, older caches are rebuilt.
+const indexVersion = 2
+
+var ErrNoteNotFound = errors.New("note not found in the index")
+
+// Parsed header and links of a single note.
+type Note struct {
+	Path    string         `json:"path"`     // path relative to the vault
+	ModTime time.Time      `json:"mod_time"` // modification time when the note was parsed
+	Size    int64          `json:"size"`     // size when the note was parsed
+	Kind    string         `json:"kind"`     // kind header of the note
+	Header  map[string]any `json:"header"`   // raw header
+	Links   []client.Link  `json:"links"`    // links in the header and the content
+	Error   string         `json:"error"`    // parse error of the note, header and links are empty if set
+}
+
+// Index of all notes under the vault, cached on disk and updated
+// incrementally by comparing the modification times.
+type Index struct {
+	Version int              `json:"version"`
+	Notes   map[string]*Note `json:"notes"` // keyed by the path relative to the vault
+
+	vaultDir string
+	bclient  *client.BufferClient
+	byName   map[string][]*Note // lower cased note names, built on first use
+}
+
+// Loads the cached index of the vault and brings it up to date. The cache is
+// rebuilt if it is missing or unreadable.
+func Open(bclient *client.BufferClient, vaultDir string) (*Index, error) {
+	idx := Load(bclient, vaultDir)
+	if _, err := idx.Update(); err != nil {
+		return nil, err
+	}
+	if err := idx.Save(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Loads the cached index without updating it, returns an empty index if
+// there is no usable cache.
+func Load(bclient *client.BufferClient, vaultDir string) *Index {
+	logger := log.GlobalLogger
+	idx := &Index{
+		Version:  indexVersion,
+		Notes:    map[string]*Note{},
+		vaultDir: vaultDir,
+		bclient:  bclient,
+	}
+
+	data, err := os.ReadFile(idx.cachePath())
+	if err != nil {
+		return idx
+	}
+
+	var cached Index
+	if err := json.Unmarshal(data, &cached); err != nil || cached.Version != indexVersion {
+		logger.Debugf("ignoring index cache: %v.\n", err)
+		return idx
+	}
+	if cached.Notes != nil {
+		idx.Notes = cached.Notes
+	}
+	return idx
+}
+
+// Parses the notes that are new or modified since the last update and drops
+// the deleted ones. Returns the number of notes that changed.
+func (idx *Index) Update() (int, error) {
+	changed := 0
+	seen := map[string]bool{}
+	idx.byName = nil
+
+	err := filepath.WalkDir(idx.vaultDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != idx.vaultDir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir // .soa, .git, .obsidian and such
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".md" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(idx.vaultDir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		seen[rel] = true
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if note, ok := idx.Notes[rel]; ok && note.ModTime.Equal(info.ModTime()) && note.Size == info.Size() {
+			return nil
+		}
+
+		idx.Notes[rel] = idx.parse(path, rel, info)
+		changed++
+		return nil
+	})
+	if err != nil {
+		return changed, err
+	}
+
+	for rel := range idx.Notes {
+		if !seen[rel] {
+			delete(idx.Notes, rel)
+			changed++
+		}
+	}
+
+	return changed, nil
+}
+
+// Parses the note, notes that cannot be parsed are still indexed with their
+// error so they can be reported.
+func (idx *Index) parse(path string, rel string, info fs.FileInfo) *Note {
+	note := &Note{
+		Path:    rel,
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+		Header:  map[string]any{},
+		Links:   []client.Link{},
+	}
+
+	buff, err := idx.bclient.NewBufferFromFile(path, false)
+	if err != nil {
+		note.Error = err.Error()
+		return note
+	}
+
+	note.Kind, _ = buff.Header["kind"].(string)
+	note.Header = buff.Header
+	note.Links = buff.Links()
+	return note
+}
+
+// Writes the index to its cache file under the vault.
+func (idx *Index) Save() error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+
+	path := idx.cachePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}, 0)
+}
+
+// Returns the notes sorted by their path.
+func (idx *Index) All() []*Note {
+	notes := make([]*Note, 0, len(idx.Notes))
+	for _, note := range idx.Notes {
+		notes = append(notes, note)
+	}
+	sort.Slice(notes, func(i, j int) bool {
+		return notes[i].Path < notes[j].Path
+	})
+	return notes
+}
+
+// Returns the note with the given path, the path can be absolute or relative
+// to the vault.
+func (idx *Index) Get(path string) (*Note, error) {
+	rel := path
+	if filepath.IsAbs(path) {
+		var err error
+		if rel, err = filepath.Rel(idx.vaultDir, path); err != nil {
+			return nil, err
+		}
+	}
+
+	note, ok := idx.Notes[filepath.ToSlash(rel)]
+	if !ok {
+		return nil, ErrNoteNotFound
+	}
+	return note, nil
+}
+
+// Returns the absolute path of the note.
+func (idx *Index) Abs(note *Note) string {
+	return idx.abs(note.Path)
+}
+
+func (idx *Index) abs(rel string) string {
+	return filepath.Join(idx.vaultDir, filepath.FromSlash(rel))
+}
+
+func (idx *Index) cachePath() string {
+	return filepath.Join(idx.vaultDir, config.DefaultIndexFile)
+}
+
+// Returns the title of the note from its header, falls back to the filename.
+func (n *Note) Title() string {
+	for _, key := range []string{"title", "question"} {
+		if title := n.HeaderString(key); title != "" {
+			return title
+		}
+	}
+	return strings.TrimSuffix(filepath.Base(n.Path), ".md")
+}
+
+// Returns the created header of the note as date, yaml timestamps are cut to
+// their date.
+func (n *Note) Created() (datetime.Date, error) {
+	created := n.HeaderString("created")
+	if len(created) > len("2006-01-02") {
+		created = created[:len("2006-01-02")]
+	}
+	return datetime.ParseDate(created)
+}
+
+// Returns the header value as string, lists are joined with commas.
+func (n *Note) HeaderString(key string) string {
+	switch v := n.Header[key].(type) {
+	case nil:
+		return ""
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return strings.Join(values, ", ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Reports whether the header value equals the given value, for lists any
+// of the items can match. The comparison is case insensitive.
+func (n *Note) Match(key string, value string) bool {
+	switch v := n.Header[key].(type) {
+	case nil:
+		return false
+	case []any:
+		for _, item := range v {
+			if strings.EqualFold(fmt.Sprint(item), value) {
+				return true
+			}
+		}
+		return false
+	default:
+		return strings.EqualFold(fmt.Sprint(v), value)
+	}
+}
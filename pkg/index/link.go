@@ -0,0 +1,115 @@
+package index
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ubombar/soa/pkg/client"
+)
+
+// Link from the source note, Target is nil if the link cannot be resolved.
+type ResolvedLink struct {
+	Source *Note
+	Target *Note
+	Link   client.Link
+}
+
+// Returns the name of the note used in wiki links, the filename without the
+// extension.
+func (n *Note) Name() string {
+	return strings.TrimSuffix(path.Base(n.Path), ".md")
+}
+
+// Returns the note the link points to. Paths are tried relative to the vault
+// and to the source note, names are matched against the note filenames with
+// the notes in the same folder preferred.
+func (idx *Index) Resolve(source *Note, link client.Link) (*Note, bool) {
+	if !link.IsNote() {
+		return nil, false
+	}
+
+	target := link.Target
+	if path.Ext(target) != ".md" {
+		target += ".md"
+	}
+
+	candidates := []string{}
+	if link.Type == client.MarkdownLink && !strings.HasPrefix(target, "/") {
+		candidates = append(candidates, path.Join(path.Dir(source.Path), target))
+	}
+	candidates = append(candidates, path.Clean(strings.TrimPrefix(target, "/")))
+	if link.Type != client.MarkdownLink {
+		candidates = append(candidates, path.Join(path.Dir(source.Path), target))
+	}
+	for _, candidate := range candidates {
+		if note, ok := idx.Notes[candidate]; ok {
+			return note, true
+		}
+	}
+
+	// match by name, case insensitive like most editors
+	matches := idx.notesByName(strings.TrimSuffix(path.Base(target), ".md"))
+	for _, note := range matches {
+		if path.Dir(note.Path) == path.Dir(source.Path) {
+			return note, true
+		}
+	}
+	if len(matches) != 0 {
+		return matches[0], true
+	}
+	return nil, false
+}
+
+// Returns the note given by its path or name.
+func (idx *Index) Find(ref string) (*Note, error) {
+	if note, err := idx.Get(ref); err == nil {
+		return note, nil
+	}
+	if matches := idx.notesByName(strings.TrimSuffix(path.Base(ref), ".md")); len(matches) != 0 {
+		return matches[0], nil
+	}
+	return nil, ErrNoteNotFound
+}
+
+// Returns the notes with the given name sorted by their path.
+func (idx *Index) notesByName(name string) []*Note {
+	if idx.byName == nil {
+		idx.byName = map[string][]*Note{}
+		for _, note := range idx.All() {
+			key := strings.ToLower(note.Name())
+			idx.byName[key] = append(idx.byName[key], note)
+		}
+	}
+	return idx.byName[strings.ToLower(name)]
+}
+
+// Returns the links of the note with their targets resolved.
+func (idx *Index) Outlinks(source *Note) []ResolvedLink {
+	links := []ResolvedLink{}
+	for _, link := range source.Links {
+		target, _ := idx.Resolve(source, link)
+		links = append(links, ResolvedLink{Source: source, Target: target, Link: link})
+	}
+	return links
+}
+
+// Returns the links of the other notes that point to the target note, sorted
+// by the source path.
+func (idx *Index) Backlinks(target *Note) []ResolvedLink {
+	backlinks := []ResolvedLink{}
+	for _, source := range idx.All() {
+		if source == target {
+			continue
+		}
+		for _, link := range idx.Outlinks(source) {
+			if link.Target == target {
+				backlinks = append(backlinks, link)
+			}
+		}
+	}
+	sort.SliceStable(backlinks, func(i, j int) bool {
+		return backlinks[i].Source.Path < backlinks[j].Source.Path
+	})
+	return backlinks
+}
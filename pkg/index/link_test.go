@@ -0,0 +1,87 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/ubombar/soa/pkg/client"
+)
+
+func TestResolve(t *testing.T) {
+	idx, _ := openVault(t, map[string]string{
+		"a/Source.md":     "",
+		"a/Same.md":       "",
+		"b/Same.md":       "",
+		"b/Other.md":      "",
+		"c/Other.md":      "",
+		"b/sub/Nested.md": "",
+	})
+	source, err := idx.Get("a/Source.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		link client.Link
+		want string // path of the target, empty if unresolved
+	}{
+		{client.Link{Type: client.WikiLink, Target: "Same"}, "a/Same.md"},
+		{client.Link{Type: client.WikiLink, Target: "same"}, "a/Same.md"},
+		{client.Link{Type: client.WikiLink, Target: "Other"}, "b/Other.md"},
+		{client.Link{Type: client.WikiLink, Target: "c/Other"}, "c/Other.md"},
+		{client.Link{Type: client.WikiLink, Target: "b/sub/Nested.md"}, "b/sub/Nested.md"},
+		{client.Link{Type: client.MarkdownLink, Target: "../b/Same.md"}, "b/Same.md"},
+		{client.Link{Type: client.MarkdownLink, Target: "Same.md"}, "a/Same.md"},
+		{client.Link{Type: client.MarkdownLink, Target: "/b/Same.md"}, "b/Same.md"},
+		{client.Link{Type: client.HeaderLink, Target: "Nested"}, "b/sub/Nested.md"},
+		{client.Link{Type: client.WikiLink, Target: "Missing"}, ""},
+		{client.Link{Type: client.WikiLink, Target: "Same.png"}, ""},
+	}
+	for _, test := range tests {
+		target, ok := idx.Resolve(source, test.link)
+		got := ""
+		if ok {
+			got = target.Path
+		}
+		if got != test.want {
+			t.Errorf("%s link %q: got %q, want %q", test.link.Type, test.link.Target, got, test.want)
+		}
+	}
+}
+
+func TestBacklinks(t *testing.T) {
+	idx, _ := openVault(t, map[string]string{
+		"Target.md":  "---\nkind: permanent\n---\n",
+		"b/Wiki.md":  "---\nkind: permanent\n---\n[[Target]] and [[Target#Part|again]]\n",
+		"a/Md.md":    "---\nkind: permanent\n---\n[t](../Target.md)\n",
+		"Header.md":  "---\nkind: question\nfrom: Target\n---\n",
+		"Code.md":    "---\nkind: permanent\n---\n`[[Target]]`\n",
+		"Section.md": "---\nkind: permanent\n---\n<!-- soa:backlinks:begin -->\n- [[Target]]\n<!-- soa:backlinks:end -->\n",
+		"Self.md":    "---\nkind: permanent\n---\n[[Self]]\n",
+	})
+	target, err := idx.Find("target")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, backlink := range idx.Backlinks(target) {
+		got = append(got, backlink.Source.Path+" "+string(backlink.Link.Type))
+	}
+	want := []string{"Header.md header", "a/Md.md markdown", "b/Wiki.md wiki", "b/Wiki.md wiki"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	self, err := idx.Find("Self.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backlinks := idx.Backlinks(self); len(backlinks) != 0 {
+		t.Errorf("links of a note to itself are backlinks: %+v", backlinks)
+	}
+}
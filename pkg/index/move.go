@@ -0,0 +1,298 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ubombar/soa/api"
+	"github.com/ubombar/soa/internal/util"
+	"github.com/ubombar/soa/pkg/client"
+)
+
+var (
+	ErrNoteExists    = errors.New("note already exists")
+	ErrOutsideVault  = errors.New("path is outside of the vault")
+	ErrCannotRetitle = errors.New("titles of literature notes come from zotero")
+	ErrMalformedNote = errors.New("note cannot be parsed")
+)
+
+// Change to a single note. The moved note is renamed from Path to NewPath,
+// the other notes keep their path.
+type Edit struct {
+	Path    string         // path of the note relative to the vault before the edit
+	NewPath string         // path of the note relative to the vault after the edit
+	Old     []byte         // contents of the note before the edit
+	Buffer  *client.Buffer // note after the edit
+}
+
+// Header key holding the title of notes of the given kind.
+func titleKey(kind string) string {
+	if kind == (api.QuestionHeader{}).Kind() {
+		return "question"
+	}
+	return "title"
+}
+
+// Title part of the filenames of notes, see the filename functions in util.
+var filenameTitleRegexps = map[string]*regexp.Regexp{
+	(api.QuestionHeader{}).Kind():  regexp.MustCompile(`^Q \d{4}-\d{2}-\d{2} (.+)\.md$`),
+	(api.MeetingHeader{}).Kind():   regexp.MustCompile(`^M \d{4}-\d{2}-\d{2} (.+)\.md$`),
+	(api.PermanentHeader{}).Kind(): regexp.MustCompile(`^P \d{14}-[a-z0-9]+ (.+)\.md$`),
+}
+
+// Returns the title written in the filename of a note of the given kind, false
+// if the filename does not follow the naming of that kind.
+func TitleFromFilename(kind string, filename string) (string, bool) {
+	re, ok := filenameTitleRegexps[kind]
+	if !ok {
+		return "", false
+	}
+	match := re.FindStringSubmatch(filename)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// Returns the filename the note would get with the given title, named the
+// same way new notes of its kind are named.
+func (idx *Index) Filename(note *Note, title string) (string, error) {
+	var filename string
+	switch note.Kind {
+	case (api.QuestionHeader{}).Kind(), (api.MeetingHeader{}).Kind():
+		created, err := note.Created()
+		if err != nil {
+			return "", fmt.Errorf("cannot parse created: %w", err)
+		}
+		if note.Kind == (api.QuestionHeader{}).Kind() {
+			filename = util.QuestionFilename(title, created)
+		} else {
+			filename = util.MeetingFilename(title, created)
+		}
+	case (api.PermanentHeader{}).Kind():
+		filename = util.PermanentFilename(note.HeaderString("id"), title)
+	case (api.LiteratureHeader{}).Kind():
+		return "", ErrCannotRetitle
+	default:
+		filename = title + ".md"
+	}
+	return util.SanitizeName(filename)
+}
+
+// Plans moving the note to the new path relative to the vault. The links of
+// the other notes pointing to it are rewritten, and so are the relative links
+// of the note itself if it changes folder. If title is not empty the title
+// header and the top heading of the note are updated as well. Nothing is
+// written until the edits are applied.
+func (idx *Index) Move(note *Note, newPath string, title string) ([]Edit, error) {
+	if note.Error != "" {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedNote, note.Error)
+	}
+
+	newPath = path.Clean(strings.TrimPrefix(newPath, "/"))
+	if path.Ext(newPath) != ".md" {
+		newPath += ".md"
+	}
+	if newPath == ".." || strings.HasPrefix(newPath, "../") {
+		return nil, fmt.Errorf("%w: %s", ErrOutsideVault, newPath)
+	}
+	if newPath != note.Path {
+		other, ok := idx.Notes[newPath]
+		// renames changing only the case are the same file on some systems
+		if (ok && other != note) || (!strings.EqualFold(newPath, note.Path) && util.FileExists(idx.abs(newPath))) {
+			return nil, fmt.Errorf("%w: %s", ErrNoteExists, newPath)
+		}
+	}
+
+	edits := []Edit{}
+
+	edit, err := idx.newEdit(note)
+	if err != nil {
+		return nil, err
+	}
+	edit.NewPath = newPath
+	edit.Buffer.Origin = idx.abs(newPath)
+	if title != "" {
+		setTitle(edit.Buffer, note.Kind, title)
+	}
+	idx.rewriteLinks(edit.Buffer, note, newPath, note, newPath)
+	edits = append(edits, edit)
+
+	// notes linking to it, and the ones it links to as their backlinks
+	// section might list it
+	sources := map[*Note]bool{}
+	for _, backlink := range idx.Backlinks(note) {
+		sources[backlink.Source] = true
+	}
+	for _, outlink := range idx.Outlinks(note) {
+		if outlink.Target != nil && outlink.Target.Error == "" {
+			sources[outlink.Target] = true
+		}
+	}
+	delete(sources, note)
+
+	others := []*Note{}
+	for source := range sources {
+		others = append(others, source)
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].Path < others[j].Path
+	})
+
+	for _, source := range others {
+		edit, err := idx.newEdit(source)
+		if err != nil {
+			return nil, err
+		}
+		if idx.rewriteLinks(edit.Buffer, source, source.Path, note, newPath) {
+			edits = append(edits, edit)
+		}
+	}
+
+	return edits, nil
+}
+
+// Plans renaming the note after the new title, see Move.
+func (idx *Index) Retitle(note *Note, title string) ([]Edit, error) {
+	filename, err := idx.Filename(note, title)
+	if err != nil {
+		return nil, err
+	}
+	return idx.Move(note, path.Join(path.Dir(note.Path), filename), title)
+}
+
+// Writes the edits and updates the index.
+func (idx *Index) Apply(edits []Edit) error {
+	if err := idx.bclient.Lock(); err != nil {
+		return err
+	}
+	defer idx.bclient.Unlock()
+
+	for _, edit := range edits {
+		if edit.NewPath != edit.Path {
+			if err := os.MkdirAll(filepath.Dir(idx.abs(edit.NewPath)), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(idx.abs(edit.Path), idx.abs(edit.NewPath)); err != nil {
+				return err
+			}
+		}
+		if err := idx.bclient.SaveBuffer(edit.Buffer); err != nil {
+			return err
+		}
+	}
+
+	if _, err := idx.Update(); err != nil {
+		return err
+	}
+	return idx.Save()
+}
+
+func (idx *Index) newEdit(note *Note) (Edit, error) {
+	old, err := os.ReadFile(idx.Abs(note))
+	if err != nil {
+		return Edit{}, err
+	}
+	buff, err := idx.bclient.NewBufferFromFile(idx.Abs(note), false)
+	if err != nil {
+		return Edit{}, err
+	}
+	return Edit{Path: note.Path, NewPath: note.Path, Old: old, Buffer: buff}, nil
+}
+
+// Sets the title header and replaces the top heading if it is the old title.
+func setTitle(buff *client.Buffer, kind string, title string) {
+	key := titleKey(kind)
+	oldTitle, _ := buff.Header[key].(string)
+	if _, ok := buff.Header[key]; ok || kind != client.UnknownKind {
+		buff.Header[key] = title
+	}
+	if oldTitle == "" {
+		return
+	}
+
+	lines := strings.SplitAfter(buff.Content.String(), "\n")
+	for i, line := range lines {
+		if strings.TrimRight(line, "\r\n") == "# "+oldTitle {
+			lines[i] = strings.Replace(line, oldTitle, title, 1)
+			buff.Content = bytes.NewBufferString(strings.Join(lines, ""))
+			return
+		}
+	}
+}
+
+// Rewrites the links of the buffer of the source note that point to the
+// moved note. The source is at sourcePath after the move, if the source is
+// the moved note its relative links are rewritten to keep their targets.
+// Returns false if nothing changed.
+func (idx *Index) rewriteLinks(buff *client.Buffer, source *Note, sourcePath string, moved *Note, newPath string) bool {
+	rewrite := func(link client.Link) (string, bool) {
+		target, ok := idx.Resolve(source, link)
+		if ok && target == moved {
+			return idx.linkTarget(link, source, sourcePath, moved, newPath), true
+		}
+
+		sourceDir, newSourceDir := path.Dir(source.Path), path.Dir(sourcePath)
+		if link.Type != client.MarkdownLink || sourceDir == newSourceDir || strings.HasPrefix(link.Target, "/") {
+			return "", false
+		}
+		// relative link of the moved note
+		targetPath := path.Join(sourceDir, link.Target)
+		if ok {
+			targetPath = target.Path
+		} else if !util.FileExists(idx.abs(targetPath)) {
+			return "", false
+		}
+		return relativePath(newSourceDir, targetPath), true
+	}
+
+	content, contentChanged := client.RewriteLinks(buff.Content.Bytes(), rewrite)
+	headerChanged := client.RewriteHeaderLinks(buff.Header, rewrite)
+	buff.Content = bytes.NewBuffer(content)
+	return contentChanged || headerChanged
+}
+
+// Returns the target pointing to the new path of the moved note, written the
+// same way as the given link: by name, relative to the source, relative to
+// the vault or as an absolute path.
+func (idx *Index) linkTarget(link client.Link, source *Note, sourcePath string, moved *Note, newPath string) string {
+	ext := path.Ext(link.Target)
+	if ext != ".md" {
+		ext = ""
+	}
+	name := strings.TrimSuffix(newPath, ".md")
+
+	if vaultDir, err := filepath.Abs(idx.vaultDir); err == nil && filepath.IsAbs(link.Target) &&
+		strings.HasPrefix(filepath.Clean(link.Target), vaultDir+string(filepath.Separator)) {
+		return filepath.Join(vaultDir, filepath.FromSlash(name)) + ext
+	}
+
+	target := strings.TrimSuffix(link.Target, ".md") + ".md"
+	switch {
+	case !strings.Contains(link.Target, "/"):
+		return path.Base(name) + ext
+	case strings.HasPrefix(link.Target, "/"):
+		return "/" + name + ext
+	case path.Join(path.Dir(source.Path), target) == moved.Path && (link.Type == client.MarkdownLink || path.Clean(target) != moved.Path):
+		return strings.TrimSuffix(relativePath(path.Dir(sourcePath), newPath), ".md") + ext
+	default:
+		return name + ext
+	}
+}
+
+// Returns the slash separated path of target relative to the dir, both
+// relative to the vault.
+func relativePath(dir string, target string) string {
+	rel, err := filepath.Rel(filepath.FromSlash(dir), filepath.FromSlash(target))
+	if err != nil {
+		return target
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,224 @@
+package index
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/ubombar/soa/pkg/client"
+	"github.com/ubombar/soa/pkg/diff"
+)
+
+// Writes the notes, keyed by their path relative to the vault, into a new
+// vault and opens its index.
+func openVault(t *testing.T, notes map[string]string) (*Index, string) {
+	dir := t.TempDir()
+	for rel, note := range notes {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(note), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	viper.Set("vault-dir", dir)
+	t.Cleanup(func() { viper.Set("vault-dir", nil) })
+	bclient, err := client.NewBufferClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := Open(bclient, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx, dir
+}
+
+func readNote(t *testing.T, dir string, rel string) string {
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func checkNote(t *testing.T, dir string, rel string, want string) {
+	t.Helper()
+	if got := readNote(t, dir, rel); got != want {
+		t.Errorf("%s\n%s", rel, diff.Unified("want", "got", want, got))
+	}
+}
+
+const (
+	oldPath = "notes/P 20240101120000-abcd Old title.md"
+	oldNote = "---\n" +
+		"kind: permanent\n" +
+		"id: 20240101120000-abcd\n" +
+		"title: Old title\n" +
+		"---\n" +
+		"# Old title\n" +
+		"\n" +
+		"See [other](../other/Other.md), [[Other]] and [[#Old title]].\n"
+	otherNote = "---\n" +
+		"kind: permanent\n" +
+		"title: Other\n" +
+		"from: \"[[P 20240101120000-abcd Old title]]\"\n" +
+		"---\n" +
+		"Wiki [[P 20240101120000-abcd Old title]], [[P 20240101120000-abcd Old title|old]] and ![[P 20240101120000-abcd Old title#Part]].\n" +
+		"Markdown [old](../notes/P%2020240101120000-abcd%20Old%20title.md#part) and [vault](</notes/P 20240101120000-abcd Old title.md>).\n" +
+		"Code `[[P 20240101120000-abcd Old title]]` and [[Unrelated]] stay.\n"
+	unrelatedNote = "---\n" +
+		"kind: permanent\n" +
+		"title: Unrelated\n" +
+		"---\n" +
+		"Nothing to see.\n"
+)
+
+func TestMove(t *testing.T) {
+	idx, dir := openVault(t, map[string]string{
+		oldPath:                 oldNote,
+		"other/Other.md":        otherNote,
+		"other/Unrelated.md":    unrelatedNote,
+		"literature/smith.md":   "---\nkind: literature\ntitle: Paper\n---\n",
+		"archive/2024/Dummy.md": "---\nkind: permanent\n---\n",
+	})
+	note, err := idx.Get(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newPath := "archive/2024/P 20240101120000-abcd New title.md"
+	edits, err := idx.Move(note, "archive/2024/P 20240101120000-abcd New title", "New title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 2 || edits[0].NewPath != newPath || edits[1].Path != "other/Other.md" {
+		t.Fatalf("unexpected edits %+v", edits)
+	}
+
+	// nothing is written before the edits are applied
+	checkNote(t, dir, oldPath, oldNote)
+	if err := idx.Apply(edits); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(oldPath))); !os.IsNotExist(err) {
+		t.Errorf("old note is still there: %v", err)
+	}
+	checkNote(t, dir, newPath, "---\n"+
+		"kind: permanent\n"+
+		"id: 20240101120000-abcd\n"+
+		"title: New title\n"+
+		"---\n"+
+		"# New title\n"+
+		"\n"+
+		"See [other](../../other/Other.md), [[Other]] and [[#Old title]].\n")
+	checkNote(t, dir, "other/Other.md", "---\n"+
+		"kind: permanent\n"+
+		"title: Other\n"+
+		"from: '[[P 20240101120000-abcd New title]]'\n"+
+		"---\n"+
+		"Wiki [[P 20240101120000-abcd New title]], [[P 20240101120000-abcd New title|old]] and ![[P 20240101120000-abcd New title#Part]].\n"+
+		"Markdown [old](../archive/2024/P%2020240101120000-abcd%20New%20title.md#part) and [vault](</archive/2024/P 20240101120000-abcd New title.md>).\n"+
+		"Code `[[P 20240101120000-abcd Old title]]` and [[Unrelated]] stay.\n")
+	checkNote(t, dir, "other/Unrelated.md", unrelatedNote)
+
+	if _, err := idx.Get(newPath); err != nil {
+		t.Errorf("index is not updated: %v", err)
+	}
+	if _, err := idx.Get(oldPath); !errors.Is(err, ErrNoteNotFound) {
+		t.Errorf("old path is still indexed: %v", err)
+	}
+}
+
+func TestMoveErrors(t *testing.T) {
+	idx, _ := openVault(t, map[string]string{
+		oldPath:             oldNote,
+		"other/Other.md":    otherNote,
+		"literature/lit.md": "---\nkind: literature\ntitle: Paper\n---\n",
+	})
+	note, err := idx.Get(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	literature, err := idx.Get("literature/lit.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := idx.Move(note, "other/Other.md", ""); !errors.Is(err, ErrNoteExists) {
+		t.Errorf("moving onto another note: got %v", err)
+	}
+	if _, err := idx.Move(note, "../outside", ""); !errors.Is(err, ErrOutsideVault) {
+		t.Errorf("moving outside of the vault: got %v", err)
+	}
+	if _, err := idx.Retitle(literature, "New"); !errors.Is(err, ErrCannotRetitle) {
+		t.Errorf("retitling literature: got %v", err)
+	}
+}
+
+func TestRetitle(t *testing.T) {
+	idx, dir := openVault(t, map[string]string{
+		oldPath:          oldNote,
+		"other/Other.md": otherNote,
+		"questions/Q 2024-03-01 Why.md": "---\n" +
+			"kind: question\n" +
+			"created: 2024-03-01\n" +
+			"question: Why\n" +
+			"---\n" +
+			"# Why\n",
+		"questions/Follow up.md": "---\n" +
+			"kind: question\n" +
+			"created: 2024-03-02\n" +
+			"question: Follow up\n" +
+			"from: Q 2024-03-01 Why\n" +
+			"---\n",
+	})
+
+	question, err := idx.Get("questions/Q 2024-03-01 Why.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edits, err := idx.Retitle(question, "Why not: really?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Apply(edits); err != nil {
+		t.Fatal(err)
+	}
+	checkNote(t, dir, "questions/Q 2024-03-01 Why not- really?.md", "---\n"+
+		"kind: question\n"+
+		"created: 2024-03-01\n"+
+		"question: 'Why not: really?'\n"+
+		"---\n"+
+		"# Why not: really?\n")
+	checkNote(t, dir, "questions/Follow up.md", "---\n"+
+		"kind: question\n"+
+		"created: 2024-03-02\n"+
+		"question: Follow up\n"+
+		"from: Q 2024-03-01 Why not- really?\n"+
+		"---\n")
+
+	note, err := idx.Get(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edits, err = idx.Retitle(note, "Renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Apply(edits); err != nil {
+		t.Fatal(err)
+	}
+	got := readNote(t, dir, "other/Other.md")
+	for _, link := range []string{"[[P 20240101120000-abcd Renamed]]", "(../notes/P%2020240101120000-abcd%20Renamed.md#part)"} {
+		if !strings.Contains(got, link) {
+			t.Errorf("%s is not in\n%s", link, got)
+		}
+	}
+}
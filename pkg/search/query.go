@@ -0,0 +1,130 @@
+package search
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Header keys that can be written in their singular form in queries.
+var fieldAliases = map[string]string{
+	"tag":      "tags",
+	"author":   "authors",
+	"attendee": "attendees",
+	"source":   "sources",
+	"link":     "links",
+}
+
+// Header keys are snake cased identifiers such as citation_key.
+var fieldKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Parsed search query. Every term, phrase and field has to match.
+type Query struct {
+	Terms   []string   // single tokens
+	Phrases [][]string // tokens that have to appear one after the other
+	Fields  []Field    // header fields
+}
+
+// Header field the note has to match, written as key:value in the query.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Parses queries such as `kind:question tag:networking "path changes" probe`.
+// Quoted text is a phrase, key:value is a header field and the rest are
+// terms. Times such as 10:30 and urls such as http://x are terms.
+func ParseQuery(raw string) *Query {
+	q := &Query{}
+
+	for _, part := range splitQuery(raw) {
+		if strings.HasPrefix(part, `"`) {
+			phrase := tokenize(strings.Trim(part, `"`))
+			switch len(phrase) {
+			case 0:
+			case 1:
+				q.Terms = append(q.Terms, phrase[0])
+			default:
+				q.Phrases = append(q.Phrases, phrase)
+			}
+			continue
+		}
+
+		if key, value, ok := parseField(part); ok {
+			q.Fields = append(q.Fields, Field{Key: key, Value: value})
+			continue
+		}
+
+		q.Terms = append(q.Terms, tokenize(part)...)
+	}
+
+	return q
+}
+
+// Splits key:value parts of the query. Only known keys and keys shaped like
+// identifiers are fields, the value of a url starts with //.
+func parseField(part string) (string, string, bool) {
+	key, value, ok := strings.Cut(part, ":")
+	if !ok || value == "" {
+		return "", "", false
+	}
+	if alias, ok := fieldAliases[key]; ok {
+		return alias, value, true
+	}
+	if !fieldKeyRegexp.MatchString(key) || strings.HasPrefix(value, "//") {
+		return "", "", false
+	}
+	return key, value, true
+}
+
+// Returns the terms of the query including the ones in the phrases.
+func (q *Query) terms() []string {
+	terms := []string{}
+	for _, term := range q.Terms {
+		if !containsString(terms, term) {
+			terms = append(terms, term)
+		}
+	}
+	for _, phrase := range q.Phrases {
+		for _, term := range phrase {
+			if !containsString(terms, term) {
+				terms = append(terms, term)
+			}
+		}
+	}
+	return terms
+}
+
+// Splits the query on spaces while keeping quoted phrases together.
+func splitQuery(raw string) []string {
+	parts := []string{}
+	var current strings.Builder
+	quoted := false
+
+	flush := func() {
+		if current.Len() != 0 {
+			parts = append(parts, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, r := range raw {
+		switch {
+		case r == '"':
+			if quoted {
+				current.WriteRune(r)
+				flush()
+			} else {
+				flush()
+				current.WriteRune(r)
+			}
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+
+	return parts
+}
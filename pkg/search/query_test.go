@@ -0,0 +1,58 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want Query
+	}{
+		{
+			raw:  `kind:question tag:networking "path changes" probe`,
+			want: Query{Terms: []string{"probe"}, Phrases: [][]string{{"path", "changes"}}, Fields: []Field{{"kind", "question"}, {"tags", "networking"}}},
+		},
+		{
+			raw:  "citation_key:smith2020 author:Smith",
+			want: Query{Fields: []Field{{"citation_key", "smith2020"}, {"authors", "Smith"}}},
+		},
+		{
+			raw:  "meeting at 10:30",
+			want: Query{Terms: []string{"meeting", "at", "10", "30"}},
+		},
+		{
+			raw:  "http://example.com/a",
+			want: Query{Terms: []string{"http", "example", "com", "a"}},
+		},
+		{
+			raw:  "dir/name:x :x x: a:b:c",
+			want: Query{Terms: []string{"dir", "name", "x", "x", "x"}, Fields: []Field{{"a", "b:c"}}},
+		},
+		{
+			raw:  `"single" "" "Two Words`,
+			want: Query{Terms: []string{"single"}, Phrases: [][]string{{"two", "words"}}},
+		},
+	}
+	for _, test := range tests {
+		got := ParseQuery(test.raw)
+		if !reflect.DeepEqual(normalizeQuery(*got), normalizeQuery(test.want)) {
+			t.Errorf("%s: got %+v, want %+v", test.raw, *got, test.want)
+		}
+	}
+}
+
+// Makes empty and nil slices compare equal.
+func normalizeQuery(q Query) Query {
+	if len(q.Terms) == 0 {
+		q.Terms = nil
+	}
+	if len(q.Phrases) == 0 {
+		q.Phrases = nil
+	}
+	if len(q.Fields) == 0 {
+		q.Fields = nil
+	}
+	return q
+}
@@ -0,0 +1,450 @@
+package search
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ubombar/soa/internal/config"
+	"github.com/ubombar/soa/internal/log"
+	"github.com/ubombar/soa/internal/util"
+	"github.com/ubombar/soa/pkg/client"
+	"github.com/ubombar/soa/pkg/index"
+)
+
+// Bump this when the persisted format changes, older indexes are rebuilt.
+const searchVersion = 2
+
+// Positions skipped between the header and the content, so that phrases do
+// not match across them.
+const positionGap = 100
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Indexed state of a single note.
+type Document struct {
+	ModTime time.Time // modification time of the note when it was indexed
+	Size    int64     // size of the note when it was indexed
+	Length  int       // number of tokens
+	Terms   []string  // distinct terms, used to drop the postings on update
+}
+
+// Inverted index of the header values and contents of the notes, persisted
+// under the vault and updated from the vault index.
+type Index struct {
+	Version   int
+	Documents map[string]*Document        // keyed by the note path relative to the vault
+	Postings  map[string]map[string][]int // term -> note path -> token positions
+
+	vaultIndex *index.Index
+	bclient    *client.BufferClient
+	vaultDir   string
+}
+
+// A single search hit.
+type Result struct {
+	Note    *index.Note
+	Score   float64
+	Snippet string // part of the content around the first match, matches are wrapped with the highlight strings
+}
+
+// Loads the persisted search index and brings it up to date with the vault
+// index.
+func Open(bclient *client.BufferClient, vaultIndex *index.Index, vaultDir string) (*Index, error) {
+	s := load(vaultDir)
+	s.vaultIndex = vaultIndex
+	s.bclient = bclient
+	s.vaultDir = vaultDir
+
+	changed, err := s.update()
+	if err != nil {
+		return nil, err
+	}
+	if changed != 0 {
+		if err := s.save(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func load(vaultDir string) *Index {
+	logger := log.GlobalLogger
+	empty := &Index{
+		Version:   searchVersion,
+		Documents: map[string]*Document{},
+		Postings:  map[string]map[string][]int{},
+	}
+
+	f, err := os.Open(filepath.Join(vaultDir, config.DefaultSearchIndexFile))
+	if err != nil {
+		return empty
+	}
+	defer f.Close()
+
+	var s Index
+	if err := gob.NewDecoder(f).Decode(&s); err != nil || s.Version != searchVersion {
+		logger.Debugf("ignoring search index: %v.\n", err)
+		return empty
+	}
+	if s.Documents == nil || s.Postings == nil {
+		return empty
+	}
+	return &s
+}
+
+func (s *Index) save() error {
+	path := filepath.Join(s.vaultDir, config.DefaultSearchIndexFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return util.WriteFileAtomic(path, func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(s)
+	}, 0)
+}
+
+// Indexes the notes that changed since the last update and drops the deleted
+// ones. Returns the number of notes that changed.
+func (s *Index) update() (int, error) {
+	changed := 0
+
+	for _, note := range s.vaultIndex.All() {
+		doc, ok := s.Documents[note.Path]
+		if ok && doc.ModTime.Equal(note.ModTime) && doc.Size == note.Size {
+			continue
+		}
+		if ok {
+			s.remove(note.Path)
+		}
+
+		content := ""
+		if note.Error == "" {
+			buff, err := s.bclient.NewBufferFromFile(s.vaultIndex.Abs(note), false)
+			if err != nil {
+				return changed, err
+			}
+			content = contentText(buff.Content.String())
+		}
+		s.add(note, headerText(note.Header), content)
+		changed++
+	}
+
+	for path := range s.Documents {
+		if _, err := s.vaultIndex.Get(path); err != nil {
+			s.remove(path)
+			changed++
+		}
+	}
+
+	return changed, nil
+}
+
+func (s *Index) add(note *index.Note, header string, content string) {
+	headerTokens := tokenize(header)
+	contentTokens := tokenize(content)
+	doc := &Document{
+		ModTime: note.ModTime,
+		Size:    note.Size,
+		Length:  len(headerTokens) + len(contentTokens),
+	}
+
+	addToken := func(token string, position int) {
+		postings, ok := s.Postings[token]
+		if !ok {
+			postings = map[string][]int{}
+			s.Postings[token] = postings
+		}
+		if _, ok := postings[note.Path]; !ok {
+			doc.Terms = append(doc.Terms, token)
+		}
+		postings[note.Path] = append(postings[note.Path], position)
+	}
+	for position, token := range headerTokens {
+		addToken(token, position)
+	}
+	for position, token := range contentTokens {
+		addToken(token, len(headerTokens)+positionGap+position)
+	}
+
+	s.Documents[note.Path] = doc
+}
+
+func (s *Index) remove(path string) {
+	doc, ok := s.Documents[path]
+	if !ok {
+		return
+	}
+	for _, term := range doc.Terms {
+		delete(s.Postings[term], path)
+		if len(s.Postings[term]) == 0 {
+			delete(s.Postings, term)
+		}
+	}
+	delete(s.Documents, path)
+}
+
+// Returns the notes matching every term, phrase and field of the query,
+// ranked by BM25 over the terms.
+func (s *Index) Search(q *Query, limit int, highlightStart string, highlightEnd string) ([]Result, error) {
+	results := []Result{}
+
+	// candidates are the notes containing all the terms of the query
+	terms := q.terms()
+	var candidates []string
+	if len(terms) == 0 {
+		for path := range s.Documents {
+			candidates = append(candidates, path)
+		}
+	} else {
+		for path := range s.Postings[terms[0]] {
+			candidates = append(candidates, path)
+		}
+	}
+
+	avgLength := s.averageLength()
+	for _, path := range candidates {
+		note, err := s.vaultIndex.Get(path)
+		if err != nil {
+			continue // index is behind, the note is deleted
+		}
+		if !s.matches(q, note, path) {
+			continue
+		}
+
+		score := 0.0
+		for _, term := range terms {
+			score += s.bm25(term, path, avgLength)
+		}
+		results = append(results, Result{Note: note, Score: score})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Note.Path < results[j].Note.Path
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	for i := range results {
+		snippet, err := s.snippet(results[i].Note, terms, highlightStart, highlightEnd)
+		if err != nil {
+			return nil, err
+		}
+		results[i].Snippet = snippet
+	}
+
+	return results, nil
+}
+
+func (s *Index) matches(q *Query, note *index.Note, path string) bool {
+	for _, field := range q.Fields {
+		if !note.Match(field.Key, field.Value) {
+			return false
+		}
+	}
+	for _, term := range q.Terms {
+		if _, ok := s.Postings[term][path]; !ok {
+			return false
+		}
+	}
+	for _, phrase := range q.Phrases {
+		if !s.containsPhrase(phrase, path) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether the tokens of the phrase appear one after the other.
+func (s *Index) containsPhrase(phrase []string, path string) bool {
+	if len(phrase) == 0 {
+		return true
+	}
+
+	for _, start := range s.Postings[phrase[0]][path] {
+		found := true
+		for offset, token := range phrase[1:] {
+			if !containsInt(s.Postings[token][path], start+offset+1) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Index) bm25(term string, path string, avgLength float64) float64 {
+	postings := s.Postings[term]
+	frequency := float64(len(postings[path]))
+	if frequency == 0 {
+		return 0
+	}
+
+	n := float64(len(s.Documents))
+	df := float64(len(postings))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+	length := float64(s.Documents[path].Length)
+
+	return idf * frequency * (bm25K1 + 1) / (frequency + bm25K1*(1-bm25B+bm25B*length/avgLength))
+}
+
+func (s *Index) averageLength() float64 {
+	if len(s.Documents) == 0 {
+		return 1
+	}
+	total := 0
+	for _, doc := range s.Documents {
+		total += doc.Length
+	}
+	return math.Max(1, float64(total)/float64(len(s.Documents)))
+}
+
+const snippetRadius = 60 // characters shown around the first match
+
+// Returns the line around the first match in the content, matches are wrapped
+// with the highlight strings.
+func (s *Index) snippet(note *index.Note, terms []string, highlightStart string, highlightEnd string) (string, error) {
+	if note.Error != "" {
+		return "", nil
+	}
+	buff, err := s.bclient.NewBufferFromFile(s.vaultIndex.Abs(note), false)
+	if err != nil {
+		return "", err
+	}
+	content := []rune(strings.Join(strings.Fields(contentText(buff.Content.String())), " "))
+
+	// find the first token of the content that is one of the terms
+	first := -1
+	spans := [][2]int{}
+	for _, span := range tokenSpans(content) {
+		token := strings.ToLower(string(content[span[0]:span[1]]))
+		if !containsString(terms, token) {
+			continue
+		}
+		if first < 0 {
+			first = span[0]
+		}
+		spans = append(spans, span)
+	}
+	if first < 0 {
+		first = 0 // matched only on the header
+	}
+
+	start := max(0, first-snippetRadius)
+	end := min(len(content), first+snippetRadius)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	position := start
+	for _, span := range spans {
+		if span[0] < position || span[1] > end {
+			continue
+		}
+		b.WriteString(string(content[position:span[0]]))
+		b.WriteString(highlightStart)
+		b.WriteString(string(content[span[0]:span[1]]))
+		b.WriteString(highlightEnd)
+		position = span[1]
+	}
+	b.WriteString(string(content[position:end]))
+	if end < len(content) {
+		b.WriteString("…")
+	}
+	return b.String(), nil
+}
+
+var htmlCommentRegexp = regexp.MustCompile(`(?s)<!--.*?-->`)
+
+// Returns the searchable text of the content, the markers of the managed
+// regions are dropped.
+func contentText(content string) string {
+	return htmlCommentRegexp.ReplaceAllString(content, "")
+}
+
+// Returns the text of the header values, keys are not indexed.
+func headerText(header map[string]any) string {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		switch v := header[key].(type) {
+		case []any:
+			for _, item := range v {
+				fmt.Fprintf(&b, "%v\n", item)
+			}
+		case nil:
+		default:
+			fmt.Fprintf(&b, "%v\n", v)
+		}
+	}
+	return b.String()
+}
+
+// Splits the text into lower cased tokens of letters and digits.
+func tokenize(text string) []string {
+	runes := []rune(text)
+	tokens := []string{}
+	for _, span := range tokenSpans(runes) {
+		tokens = append(tokens, strings.ToLower(string(runes[span[0]:span[1]])))
+	}
+	return tokens
+}
+
+// Returns the start and end offsets of the tokens in the text.
+func tokenSpans(text []rune) [][2]int {
+	spans := [][2]int{}
+	start := -1
+	for i, r := range text {
+		isToken := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isToken && start < 0 {
+			start = i
+		} else if !isToken && start >= 0 {
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	return spans
+}
+
+func containsInt(values []int, value int) bool {
+	// positions are appended in order
+	i := sort.SearchInts(values, value)
+	return i < len(values) && values[i] == value
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
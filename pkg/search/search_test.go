@@ -0,0 +1,97 @@
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/ubombar/soa/pkg/client"
+	"github.com/ubombar/soa/pkg/index"
+)
+
+// Writes the notes, keyed by their path relative to the vault, into a new
+// vault and opens its search index.
+func openVault(t *testing.T, notes map[string]string) *Index {
+	dir := t.TempDir()
+	for rel, note := range notes {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(note), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	viper.Set("vault-dir", dir)
+	t.Cleanup(func() { viper.Set("vault-dir", nil) })
+	bclient, err := client.NewBufferClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := index.Open(bclient, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(bclient, idx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func search(t *testing.T, s *Index, raw string) []string {
+	results, err := s.Search(ParseQuery(raw), 0, "[", "]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{}
+	for _, result := range results {
+		paths = append(paths, result.Note.Path)
+	}
+	return paths
+}
+
+func TestSearch(t *testing.T) {
+	s := openVault(t, map[string]string{
+		"often.md":    "---\nkind: permanent\ntitle: Routing\n---\nrouting routing routing tables\n",
+		"once.md":     "---\nkind: permanent\ntitle: Tables\n---\nrouting with many other words in a much longer note about tables\n",
+		"question.md": "---\nkind: question\nquestion: Why do paths change\ntags: [networking]\n---\nprobe the path changes\n",
+		"meeting.md":  "---\nkind: meeting\ntitle: Standup\n---\nat 10:30 we met\n",
+		"joined.md":   "---\nkind: permanent\ntitle: Path\n---\nchanges are written here\n",
+	})
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"routing", []string{"often.md", "once.md"}},
+		{"routing tables", []string{"often.md", "once.md"}},
+		{"kind:question", []string{"question.md"}},
+		{"tag:networking probe", []string{"question.md"}},
+		{`"path changes"`, []string{"question.md"}}, // not joined.md, title and content are apart
+		{`"changes path"`, []string{}},
+		{"10:30", []string{"meeting.md"}},
+		{"kind:meeting routing", []string{}},
+		{"missing", []string{}},
+	}
+	for _, test := range tests {
+		if got := search(t, s, test.query); !equalStrings(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}